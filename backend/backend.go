@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+)
+
+// ErrNotFound is returned by a Backend when a slot does not contain the
+// requested certificate or private key.
+var ErrNotFound = errors.New("not found")
+
+// Algorithm is the cryptographic algorithm of a private key generated by a
+// Backend.
+type Algorithm int
+
+const (
+	AlgorithmEC256 Algorithm = iota + 1
+	AlgorithmEC384
+	AlgorithmEd25519
+	AlgorithmRSA1024
+	AlgorithmRSA2048
+)
+
+// ParseAlgorithm returns the Algorithm identified by algo, as it would be
+// written in the configuration of a Certificate Authority.
+func ParseAlgorithm(algo string) (Algorithm, bool) {
+	switch algo {
+	case "ec256", "EC256":
+		return AlgorithmEC256, true
+
+	case "ec384", "EC384":
+		return AlgorithmEC384, true
+
+	case "ed25519", "ED25519":
+		return AlgorithmEd25519, true
+
+	case "rsa1024", "RSA1024":
+		return AlgorithmRSA1024, true
+
+	case "rsa2048", "RSA2048":
+		return AlgorithmRSA2048, true
+
+	default:
+		return 0, false
+	}
+}
+
+// Auth is used by a Backend to prompt for credentials only when an operation
+// requires them.
+type Auth struct {
+	// PIN returns the PIN used to unlock a private key. If empty, the default
+	// PIN of the Backend is used.
+	PIN func() (string, error)
+
+	// ManagementKey returns the key used to authorize changes to a slot, such
+	// as generating a private key or storing a certificate. If empty, the
+	// default management key of the Backend is used.
+	ManagementKey func() ([]byte, error)
+
+	// Passphrase returns the passphrase used to encrypt or decrypt a private
+	// key stored by the Backend. If empty, the private key is not encrypted.
+	Passphrase func() (string, error)
+}
+
+// Backend is a store of private keys and certificates that a Certificate
+// Authority can be operated from, such as a YubiKey.
+type Backend interface {
+	// Slots returns the identifiers of the slots supported by the Backend.
+	Slots() ([]string, error)
+
+	// Certificate returns the certificate stored in slot, or ErrNotFound if
+	// the slot is empty.
+	Certificate(slot string) (*x509.Certificate, error)
+
+	// SetCertificate stores cert in slot, replacing any existing certificate.
+	SetCertificate(slot string, cert *x509.Certificate) error
+
+	// GenerateKey generates a new private key in slot, replacing any existing
+	// private key, and returns its public key.
+	GenerateKey(slot string, algo Algorithm) (crypto.PublicKey, error)
+
+	// PublicKey returns the public key of the private key in slot, or
+	// ErrNotFound if the slot has no private key.
+	PublicKey(slot string) (crypto.PublicKey, error)
+
+	// PrivateKey returns a signer for the private key in slot matching pub.
+	PrivateKey(slot string, pub crypto.PublicKey) (crypto.Signer, error)
+
+	// Delete removes the certificate from slot and destroys its private key,
+	// so that it can never be used to sign again.
+	Delete(slot string) error
+
+	// Close releases any resources held by the Backend.
+	Close() error
+}
@@ -0,0 +1,576 @@
+//go:build cgo
+
+package backend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+)
+
+// these are defined by PKCS#11 v3.0, and are not exported by miekg/pkcs11.
+const (
+	ckkECEdwards           = 0x00000040
+	ckmECEdwardsKeyPairGen = 0x00001055
+	ckmEdDSA               = 0x00001057
+)
+
+// pkcs11MaxObjectsPerFind is the number of object handles requested from the
+// token at once when searching for objects.
+const pkcs11MaxObjectsPerFind = 16
+
+var (
+	oidP256    = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidP384    = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// PKCS11 is a Backend that stores private keys and certificates on a token
+// accessed through a PKCS#11 module, such as a network HSM or SoftHSM.
+//
+// The private key, public key and certificate objects of a slot are
+// identified on the token by their label.
+type PKCS11 struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	label   string
+	auth    *Auth
+
+	loggedIn bool
+}
+
+// NewPKCS11 loads the PKCS#11 module at path and opens a session with the
+// token labelled token. If label is not empty, it is used to identify objects
+// on the token instead of the slot.
+func NewPKCS11(module, token, label string, auth *Auth) (*PKCS11, error) {
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, fmt.Errorf("could not load pkcs11 module %q", module)
+	}
+
+	err := ctx.Initialize()
+	if err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("could not initialize pkcs11 module: %w", err)
+	}
+
+	p := &PKCS11{ctx: ctx, label: label, auth: auth}
+
+	id, err := p.findToken(token)
+	if err != nil {
+		p.finalize()
+		return nil, err
+	}
+
+	p.session, err = ctx.OpenSession(id, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		p.finalize()
+		return nil, fmt.Errorf("could not open session with token %q: %w", token, err)
+	}
+
+	return p, nil
+}
+
+// Slots returns the labels of the certificates stored on the token.
+func (p *PKCS11) Slots() ([]string, error) {
+	objects, err := p.findObjects([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var slots []string
+	for _, obj := range objects {
+		attrs, err := p.ctx.GetAttributeValue(p.session, obj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		slots = append(slots, string(attrs[0].Value))
+	}
+
+	return slots, nil
+}
+
+func (p *PKCS11) Certificate(slot string) (*x509.Certificate, error) {
+	obj, err := p.findObject(pkcs11.CKO_CERTIFICATE, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := p.ctx.GetAttributeValue(p.session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(attrs[0].Value)
+}
+
+func (p *PKCS11) SetCertificate(slot string, cert *x509.Certificate) error {
+	err := p.login()
+	if err != nil {
+		return err
+	}
+
+	err = p.destroyObjects(pkcs11.CKO_CERTIFICATE, slot)
+	if err != nil {
+		return err
+	}
+
+	serial, err := asn1.Marshal(cert.SerialNumber)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.ctx.CreateObject(p.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+		pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.objectLabel(slot)),
+		pkcs11.NewAttribute(pkcs11.CKA_SUBJECT, cert.RawSubject),
+		pkcs11.NewAttribute(pkcs11.CKA_ISSUER, cert.RawIssuer),
+		pkcs11.NewAttribute(pkcs11.CKA_SERIAL_NUMBER, serial),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, cert.Raw),
+	})
+	if err != nil {
+		return fmt.Errorf("could not create certificate object: %w", err)
+	}
+
+	return nil
+}
+
+func (p *PKCS11) GenerateKey(slot string, algo Algorithm) (crypto.PublicKey, error) {
+	err := p.login()
+	if err != nil {
+		return nil, err
+	}
+
+	label := p.objectLabel(slot)
+
+	// the public key is readable without logging in, as it is looked up
+	// before the PIN is required.
+	public := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	private := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	var mechanism uint
+	switch algo {
+	case AlgorithmEC256, AlgorithmEC384, AlgorithmEd25519:
+		mechanism = pkcs11.CKM_EC_KEY_PAIR_GEN
+
+		oid := oidP256
+		if algo == AlgorithmEC384 {
+			oid = oidP384
+		} else if algo == AlgorithmEd25519 {
+			oid = oidEd25519
+			mechanism = ckmECEdwardsKeyPairGen
+		}
+
+		params, err := asn1.Marshal(oid)
+		if err != nil {
+			return nil, err
+		}
+
+		public = append(public, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params))
+
+	case AlgorithmRSA1024, AlgorithmRSA2048:
+		mechanism = pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN
+
+		bits := 2048
+		if algo == AlgorithmRSA1024 {
+			bits = 1024
+		}
+
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+		)
+
+	default:
+		return nil, fmt.Errorf("algorithm not supported by pkcs11 backend")
+	}
+
+	for _, class := range []uint{pkcs11.CKO_PUBLIC_KEY, pkcs11.CKO_PRIVATE_KEY} {
+		err = p.destroyObjects(class, slot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pub, _, err := p.ctx.GenerateKeyPair(p.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, public, private)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate key pair: %w", err)
+	}
+
+	return p.publicKey(pub)
+}
+
+func (p *PKCS11) PublicKey(slot string) (crypto.PublicKey, error) {
+	obj, err := p.findObject(pkcs11.CKO_PUBLIC_KEY, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.publicKey(obj)
+}
+
+func (p *PKCS11) PrivateKey(slot string, pub crypto.PublicKey) (crypto.Signer, error) {
+	err := p.login()
+	if err != nil {
+		return nil, err
+	}
+
+	// the private key cannot be read from the token, so it is checked against
+	// the public key object generated alongside it.
+	tokenPub, err := p.PublicKey(slot)
+	if err != nil {
+		return nil, fmt.Errorf("could not get public key: %w", err)
+	}
+
+	if !publicKeyEqual(tokenPub, pub) {
+		return nil, fmt.Errorf("private key in slot %q does not match public key", slot)
+	}
+
+	obj, err := p.findObject(pkcs11.CKO_PRIVATE_KEY, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pkcs11Signer{backend: p, obj: obj, pub: pub}, nil
+}
+
+// Delete destroys the certificate, public key and private key objects of slot
+// on the token.
+func (p *PKCS11) Delete(slot string) error {
+	err := p.login()
+	if err != nil {
+		return err
+	}
+
+	for _, class := range []uint{pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_PUBLIC_KEY, pkcs11.CKO_CERTIFICATE} {
+		err = p.destroyObjects(class, slot)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *PKCS11) Close() error {
+	if p.loggedIn {
+		p.ctx.Logout(p.session)
+	}
+
+	err := p.ctx.CloseSession(p.session)
+	p.finalize()
+
+	return err
+}
+
+func (p *PKCS11) finalize() {
+	p.ctx.Finalize()
+	p.ctx.Destroy()
+}
+
+func (p *PKCS11) objectLabel(slot string) string {
+	if p.label != "" {
+		return p.label
+	}
+
+	return slot
+}
+
+func (p *PKCS11) findToken(token string) (uint, error) {
+	ids, err := p.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("could not list pkcs11 slots: %w", err)
+	}
+
+	for _, id := range ids {
+		info, err := p.ctx.GetTokenInfo(id)
+		if err != nil {
+			return 0, fmt.Errorf("could not get token info: %w", err)
+		}
+
+		if info.Label == token {
+			return id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("token %q not found", token)
+}
+
+// login authenticates the session as the normal user the first time an
+// operation requires it.
+func (p *PKCS11) login() error {
+	if p.loggedIn {
+		return nil
+	}
+
+	pin, err := p.auth.PIN()
+	if err != nil {
+		return fmt.Errorf("could not read PIN: %w", err)
+	}
+
+	err = p.ctx.Login(p.session, pkcs11.CKU_USER, pin)
+	if err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		return fmt.Errorf("could not login to token: %w", err)
+	}
+
+	p.loggedIn = true
+
+	return nil
+}
+
+func (p *PKCS11) findObjects(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	err := p.ctx.FindObjectsInit(p.session, template)
+	if err != nil {
+		return nil, err
+	}
+	defer p.ctx.FindObjectsFinal(p.session)
+
+	var objects []pkcs11.ObjectHandle
+	for {
+		found, _, err := p.ctx.FindObjects(p.session, pkcs11MaxObjectsPerFind)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(found) == 0 {
+			return objects, nil
+		}
+
+		objects = append(objects, found...)
+	}
+}
+
+// findObject returns the object of class belonging to slot, or ErrNotFound.
+func (p *PKCS11) findObject(class uint, slot string) (pkcs11.ObjectHandle, error) {
+	objects, err := p.findObjects([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.objectLabel(slot)),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(objects) < 1 {
+		return 0, ErrNotFound
+	}
+
+	return objects[0], nil
+}
+
+func (p *PKCS11) destroyObjects(class uint, slot string) error {
+	objects, err := p.findObjects([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.objectLabel(slot)),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		err = p.ctx.DestroyObject(p.session, obj)
+		if err != nil {
+			return fmt.Errorf("could not destroy existing object: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// publicKey reads the public key object obj from the token.
+func (p *PKCS11) publicKey(obj pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := p.ctx.GetAttributeValue(p.session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch keyType := bytesToUint(attrs[0].Value); keyType {
+	case pkcs11.CKK_EC, ckkECEdwards:
+		attrs, err = p.ctx.GetAttributeValue(p.session, obj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(attrs[0].Value, &oid); err != nil {
+			return nil, fmt.Errorf("could not parse curve parameters: %w", err)
+		}
+
+		// CKA_EC_POINT is a DER-encoded OCTET STRING of the point.
+		var point []byte
+		if _, err := asn1.Unmarshal(attrs[1].Value, &point); err != nil {
+			return nil, fmt.Errorf("could not parse public point: %w", err)
+		}
+
+		var curve elliptic.Curve
+		switch {
+		case oid.Equal(oidP256):
+			curve = elliptic.P256()
+
+		case oid.Equal(oidP384):
+			curve = elliptic.P384()
+
+		case oid.Equal(oidEd25519):
+			if len(point) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("invalid ed25519 public key")
+			}
+
+			return ed25519.PublicKey(point), nil
+
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", oid)
+		}
+
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, fmt.Errorf("invalid elliptic curve public key")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case pkcs11.CKK_RSA:
+		attrs, err = p.ctx.GetAttributeValue(p.session, obj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %#x", keyType)
+	}
+}
+
+// bytesToUint decodes a CK_ULONG attribute value, which is stored in the
+// native byte order of the module (little-endian on all supported platforms).
+func bytesToUint(b []byte) uint {
+	var n uint
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint(b[i])
+	}
+
+	return n
+}
+
+// pkcs11Signer is a crypto.Signer using a private key stored on a PKCS#11
+// token.
+type pkcs11Signer struct {
+	backend *PKCS11
+	obj     pkcs11.ObjectHandle
+	pub     crypto.PublicKey
+}
+
+// digestInfoPrefixes are the DER-encoded DigestInfo prefixes prepended to a
+// digest before it is signed with CKM_RSA_PKCS, as defined by RFC 8017.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *pkcs11Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var (
+		mechanism uint
+		message   = digest
+	)
+
+	switch s.pub.(type) {
+	case *ecdsa.PublicKey:
+		mechanism = pkcs11.CKM_ECDSA
+
+	case ed25519.PublicKey:
+		mechanism = ckmEdDSA
+
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, fmt.Errorf("rsa-pss signatures are not supported by pkcs11 backend")
+		}
+
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash function %s", opts.HashFunc())
+		}
+
+		mechanism = pkcs11.CKM_RSA_PKCS
+		message = append(append([]byte{}, prefix...), digest...)
+
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", s.pub)
+	}
+
+	ctx, session := s.backend.ctx, s.backend.session
+
+	err := ctx.SignInit(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, s.obj)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize signature: %w", err)
+	}
+
+	sig, err := ctx.Sign(session, message)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign: %w", err)
+	}
+
+	if mechanism == pkcs11.CKM_ECDSA {
+		// CKM_ECDSA returns the raw concatenation of r and s, where Go expects
+		// an ASN.1 encoded signature.
+		half := len(sig) / 2
+
+		return asn1.Marshal(struct {
+			R, S *big.Int
+		}{
+			R: new(big.Int).SetBytes(sig[:half]),
+			S: new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+
+	return sig, nil
+}
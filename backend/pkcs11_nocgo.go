@@ -0,0 +1,54 @@
+//go:build !cgo
+
+package backend
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+)
+
+// PKCS11 is a Backend that stores private keys and certificates on a token
+// accessed through a PKCS#11 module, such as a network HSM or SoftHSM.
+//
+// This build of yubca was compiled without cgo, which is required to load a
+// PKCS#11 module.
+type PKCS11 struct{}
+
+// NewPKCS11 always returns an error, as this build of yubca was compiled
+// without cgo.
+func NewPKCS11(module, token, label string, auth *Auth) (*PKCS11, error) {
+	return nil, errors.New("pkcs11 support requires yubca to be built with cgo")
+}
+
+func (p *PKCS11) Slots() ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (p *PKCS11) Certificate(slot string) (*x509.Certificate, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (p *PKCS11) SetCertificate(slot string, cert *x509.Certificate) error {
+	return errors.ErrUnsupported
+}
+
+func (p *PKCS11) GenerateKey(slot string, algo Algorithm) (crypto.PublicKey, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (p *PKCS11) PublicKey(slot string) (crypto.PublicKey, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (p *PKCS11) PrivateKey(slot string, pub crypto.PublicKey) (crypto.Signer, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (p *PKCS11) Delete(slot string) error {
+	return errors.ErrUnsupported
+}
+
+func (p *PKCS11) Close() error {
+	return nil
+}
@@ -0,0 +1,102 @@
+//go:build cgo
+
+package backend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestPKCS11 runs against the token given by YUBCA_TEST_PKCS11_MODULE,
+// YUBCA_TEST_PKCS11_TOKEN and YUBCA_TEST_PKCS11_PIN, such as one initialized
+// with SoftHSM, and is skipped otherwise.
+func TestPKCS11(t *testing.T) {
+	module := os.Getenv("YUBCA_TEST_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("YUBCA_TEST_PKCS11_MODULE not set")
+	}
+
+	const slot = "yubca-test"
+
+	// open connects to the token, logging in with pin when required.
+	open := func(pin func() (string, error)) *PKCS11 {
+		t.Helper()
+
+		p, err := NewPKCS11(module, os.Getenv("YUBCA_TEST_PKCS11_TOKEN"), "", &Auth{PIN: pin})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	userPIN := func() (string, error) { return os.Getenv("YUBCA_TEST_PKCS11_PIN"), nil }
+
+	p := open(userPIN)
+
+	pub, err := p.GenerateKey(slot, AlgorithmEC256)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err)
+	}
+
+	p.Close()
+
+	// the public key must be found by a session that has not logged in, as
+	// login state is shared by every session of the module.
+	p = open(func() (string, error) { return "", errors.New("unexpected login") })
+
+	tokenPub, err := p.PublicKey(slot)
+	if err != nil {
+		t.Fatalf("could not get public key without login: %s", err)
+	} else if !publicKeyEqual(tokenPub, pub) {
+		t.Fatalf("public key does not match generated key")
+	}
+
+	p.Close()
+
+	p = open(userPIN)
+	defer p.Close()
+	defer p.Delete(slot)
+
+	signer, err := p.PrivateKey(slot, pub)
+	if err != nil {
+		t.Fatalf("could not get private key: %s", err)
+	}
+
+	digest := sha256.Sum256([]byte("yubca"))
+
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("could not sign: %s", err)
+	}
+
+	if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+		t.Errorf("signature does not verify")
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.PrivateKey(slot, other.Public())
+	if err == nil {
+		t.Errorf("expected error getting private key for mismatched public key")
+	}
+
+	err = p.Delete(slot)
+	if err != nil {
+		t.Fatalf("could not delete: %s", err)
+	}
+
+	_, err = p.PublicKey(slot)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+}
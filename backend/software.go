@@ -0,0 +1,294 @@
+package backend
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+)
+
+// Software is a Backend that stores private keys and certificates as files on
+// disk. Private keys are PKCS#8 encoded, and optionally encrypted with a
+// passphrase.
+//
+// Software is intended for development and testing Certificate Authorities,
+// as its private keys can be copied by anyone with access to the disk.
+type Software struct {
+	path string
+	auth *Auth
+}
+
+// NewSoftware returns a Software Backend storing private keys and certificates
+// in the directory path.
+func NewSoftware(path string, auth *Auth) (*Software, error) {
+	if path == "" {
+		return nil, fmt.Errorf("software path is required")
+	}
+
+	return &Software{path: path, auth: auth}, nil
+}
+
+func (s *Software) Slots() ([]string, error) {
+	entries, err := os.ReadDir(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var slots []string
+	for _, entry := range entries {
+		if slot, ok := strings.CutSuffix(entry.Name(), ".key"); ok && !entry.IsDir() {
+			slots = append(slots, slot)
+		}
+	}
+
+	sort.Strings(slots)
+
+	return slots, nil
+}
+
+func (s *Software) Certificate(slot string) (*x509.Certificate, error) {
+	path, err := s.slotPath(slot, ".crt")
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := readPEM(path, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func (s *Software) SetCertificate(slot string, cert *x509.Certificate) error {
+	path, err := s.slotPath(slot, ".crt")
+	if err != nil {
+		return err
+	}
+
+	return writePEM(path, 0o644, &pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: cert.Raw,
+	})
+}
+
+func (s *Software) GenerateKey(slot string, algo Algorithm) (crypto.PublicKey, error) {
+	path, err := s.slotPath(slot, ".key")
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := generateKey(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, err := s.auth.Passphrase()
+	if err != nil {
+		return nil, fmt.Errorf("could not read passphrase: %w", err)
+	}
+
+	block := &pem.Block{Type: "PRIVATE KEY"}
+	if passphrase != "" {
+		block.Type = "ENCRYPTED PRIVATE KEY"
+	}
+
+	block.Bytes, err = pkcs8.MarshalPrivateKey(priv, []byte(passphrase), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal private key: %w", err)
+	}
+
+	err = os.MkdirAll(s.path, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writePEM(path, 0o600, block)
+	if err != nil {
+		return nil, err
+	}
+
+	return priv.Public(), nil
+}
+
+// PublicKey decrypts the private key of slot to read its public key, so may
+// prompt for the passphrase.
+func (s *Software) PublicKey(slot string) (crypto.PublicKey, error) {
+	signer, err := s.signer(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	return signer.Public(), nil
+}
+
+func (s *Software) PrivateKey(slot string, pub crypto.PublicKey) (crypto.Signer, error) {
+	signer, err := s.signer(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	if !publicKeyEqual(signer.Public(), pub) {
+		return nil, fmt.Errorf("private key in slot %q does not match public key", slot)
+	}
+
+	return signer, nil
+}
+
+// signer reads and decrypts the private key of slot.
+func (s *Software) signer(slot string) (crypto.Signer, error) {
+	path, err := s.slotPath(slot, ".key")
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := readPEM(path, "PRIVATE KEY", "ENCRYPTED PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	var passphrase string
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		passphrase, err = s.auth.Passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("could not read passphrase: %w", err)
+		}
+	}
+
+	priv, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %w", err)
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key does not support signing")
+	}
+
+	return signer, nil
+}
+
+// Delete removes the private key and certificate files of slot.
+func (s *Software) Delete(slot string) error {
+	for _, ext := range []string{".key", ".crt"} {
+		path, err := s.slotPath(slot, ext)
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Software) Close() error {
+	return nil
+}
+
+// slotPath returns the path of the file with ext storing part of slot.
+func (s *Software) slotPath(slot, ext string) (string, error) {
+	if slot == "" || slot == "." || slot == ".." || strings.ContainsAny(slot, `/\`) {
+		return "", fmt.Errorf("invalid slot name %q", slot)
+	}
+
+	return filepath.Join(s.path, slot+ext), nil
+}
+
+func generateKey(algo Algorithm) (crypto.Signer, error) {
+	switch algo {
+	case AlgorithmEC256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	case AlgorithmEC384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	case AlgorithmEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+
+	case AlgorithmRSA1024:
+		return rsa.GenerateKey(rand.Reader, 1024)
+
+	case AlgorithmRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+
+	default:
+		return nil, fmt.Errorf("unknown algorithm %d", algo)
+	}
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	ab, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+
+	bb, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(ab, bb)
+}
+
+// readPEM reads the first PEM block from path, which must be one of types. If
+// path does not exist, ErrNotFound is returned.
+func readPEM(path string, types ...string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: could not decode PEM block", path)
+	}
+
+	for _, t := range types {
+		if block.Type == t {
+			return block, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s: unexpected PEM block %q", path, block.Type)
+}
+
+// writePEM atomically replaces the contents of path with block.
+func writePEM(path string, perm fs.FileMode, block *pem.Block) error {
+	tmp := path + ".tmp"
+
+	err := os.WriteFile(tmp, pem.EncodeToMemory(block), perm)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+//go:build cgo || windows
+
+package backend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/go-piv/piv-go/v2/piv"
+)
+
+// YubiKey is a Backend that stores private keys and certificates in the PIV
+// slots of a YubiKey.
+type YubiKey struct {
+	key          *piv.YubiKey
+	auth         *Auth
+	pinProtected bool
+
+	pin  *string
+	mgmt []byte
+}
+
+// NewYubiKey connects to the id'th YubiKey connected to this machine. If
+// pinProtected is true, the management key is read from the PIN protected
+// metadata stored on the YubiKey rather than prompted for.
+func NewYubiKey(id int, pinProtected bool, auth *Auth) (*YubiKey, error) {
+	cards, err := piv.Cards()
+	if err != nil {
+		return nil, fmt.Errorf("could not list keys: %w", err)
+	}
+
+	if len(cards) < 1 {
+		return nil, fmt.Errorf("no keys found")
+	}
+
+	if id < 0 || id >= len(cards) {
+		return nil, fmt.Errorf("key id %d not found", id)
+	}
+
+	key, err := piv.Open(cards[id])
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to key: %w", err)
+	}
+
+	return &YubiKey{key: key, auth: auth, pinProtected: pinProtected}, nil
+}
+
+func (y *YubiKey) Slots() ([]string, error) {
+	return []string{"9a", "9c", "9d", "9e"}, nil
+}
+
+func (y *YubiKey) Certificate(slot string) (*x509.Certificate, error) {
+	s, err := getSlot(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := y.key.Certificate(s)
+	if errors.Is(err, piv.ErrNotFound) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	} else if isClearedCertificate(cert) {
+		return nil, ErrNotFound
+	}
+
+	return cert, nil
+}
+
+func (y *YubiKey) SetCertificate(slot string, cert *x509.Certificate) error {
+	s, err := getSlot(slot)
+	if err != nil {
+		return err
+	}
+
+	mgmt, err := y.managementKey()
+	if err != nil {
+		return err
+	}
+
+	return y.key.SetCertificate(mgmt, s, cert)
+}
+
+func (y *YubiKey) GenerateKey(slot string, algo Algorithm) (crypto.PublicKey, error) {
+	s, err := getSlot(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	a, ok := getPIVAlgorithm(algo)
+	if !ok {
+		return nil, fmt.Errorf("algorithm not supported by yubikey")
+	}
+
+	mgmt, err := y.managementKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return y.key.GenerateKey(mgmt, s, piv.Key{
+		Algorithm:   a,
+		PINPolicy:   piv.PINPolicyAlways,
+		TouchPolicy: piv.TouchPolicyAlways,
+	})
+}
+
+// PublicKey reads the public key of slot from an attestation of it, which
+// requires a YubiKey with firmware 4.3 or later. The throwaway key left in a
+// slot cleared by Delete is reported as ErrNotFound.
+func (y *YubiKey) PublicKey(slot string) (crypto.PublicKey, error) {
+	s, err := getSlot(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := y.key.Certificate(s)
+	if err == nil && isClearedCertificate(cert) {
+		return nil, ErrNotFound
+	}
+
+	cert, err = y.key.Attest(s)
+	if errors.Is(err, piv.ErrNotFound) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("could not attest slot: %w", err)
+	}
+
+	return cert.PublicKey, nil
+}
+
+func (y *YubiKey) PrivateKey(slot string, pub crypto.PublicKey) (crypto.Signer, error) {
+	s, err := getSlot(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := y.key.PrivateKey(s, pub, piv.KeyAuth{
+		PINPrompt: y.readPIN,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key does not support signing")
+	}
+
+	return signer, nil
+}
+
+// Delete clears the certificate in slot and overwrites its private key by
+// generating a throwaway key in its place, as PIV does not support deleting
+// keys.
+func (y *YubiKey) Delete(slot string) error {
+	s, err := getSlot(slot)
+	if err != nil {
+		return err
+	}
+
+	mgmt, err := y.managementKey()
+	if err != nil {
+		return err
+	}
+
+	// piv-go cannot delete the certificate object, so it is replaced with a
+	// placeholder that Certificate reports as not found. this is done first
+	// so a failure never leaves the certificate paired with the throwaway key.
+	cleared, err := clearedCertificate()
+	if err != nil {
+		return fmt.Errorf("could not create placeholder certificate: %w", err)
+	}
+
+	err = y.key.SetCertificate(mgmt, s, cleared)
+	if err != nil {
+		return fmt.Errorf("could not clear certificate: %w", err)
+	}
+
+	_, err = y.key.GenerateKey(mgmt, s, piv.Key{
+		Algorithm:   piv.AlgorithmEC256,
+		PINPolicy:   piv.PINPolicyAlways,
+		TouchPolicy: piv.TouchPolicyAlways,
+	})
+	if err != nil {
+		return fmt.Errorf("could not overwrite private key: %w", err)
+	}
+
+	return nil
+}
+
+func (y *YubiKey) Close() error {
+	return y.key.Close()
+}
+
+// readPIN prompts for the PIN the first time it is required and reuses it for
+// any further operations on this connection.
+func (y *YubiKey) readPIN() (string, error) {
+	if y.pin != nil {
+		return *y.pin, nil
+	}
+
+	pin, err := y.auth.PIN()
+	if err != nil {
+		return "", err
+	}
+
+	if pin == "" {
+		pin = piv.DefaultPIN
+	}
+
+	y.pin = &pin
+
+	return pin, nil
+}
+
+// managementKey prompts for the management key the first time it is required
+// and reuses it for any further operations on this connection.
+func (y *YubiKey) managementKey() ([]byte, error) {
+	if y.mgmt != nil {
+		return y.mgmt, nil
+	}
+
+	var mgmt []byte
+
+	if y.pinProtected {
+		pin, err := y.readPIN()
+		if err != nil {
+			return nil, fmt.Errorf("could not read PIN: %w", err)
+		}
+
+		metadata, err := y.key.Metadata(pin)
+		if err != nil {
+			return nil, fmt.Errorf("could not read PIN protected metadata: %w", err)
+		}
+
+		if metadata.ManagementKey == nil {
+			return nil, fmt.Errorf("no PIN protected management key stored on yubikey")
+		}
+
+		mgmt = *metadata.ManagementKey
+	} else {
+		key, err := y.auth.ManagementKey()
+		if err != nil {
+			return nil, fmt.Errorf("could not read management key: %w", err)
+		}
+
+		mgmt = piv.DefaultManagementKey
+		if len(key) > 0 {
+			mgmt = key
+		}
+	}
+
+	// AES management keys are only supported from firmware 5.4, and an AES-192
+	// key cannot be distinguished from a 3DES key by its length alone, so only
+	// keys of other lengths can be rejected here.
+	if v := y.key.Version(); len(mgmt) != 24 && (v.Major < 5 || (v.Major == 5 && v.Minor < 4)) {
+		return nil, fmt.Errorf("AES management keys require yubikey firmware 5.4 or later, found %d.%d.%d", v.Major, v.Minor, v.Patch)
+	}
+
+	y.mgmt = mgmt
+
+	return mgmt, nil
+}
+
+// clearedSlotName is the subject of the placeholder certificate stored by
+// Delete in a cleared slot.
+const clearedSlotName = "yubca cleared slot"
+
+// clearedCertificate returns a placeholder certificate marking a slot as
+// cleared, self-signed by a throwaway key.
+func clearedCertificate() (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: clearedSlotName},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// isClearedCertificate reports whether cert is the placeholder stored by
+// Delete.
+func isClearedCertificate(cert *x509.Certificate) bool {
+	return cert.Subject.CommonName == clearedSlotName && cert.Issuer.CommonName == clearedSlotName
+}
+
+func getSlot(slot string) (piv.Slot, error) {
+	switch slot {
+	case "9a":
+		return piv.SlotAuthentication, nil
+
+	case "9c":
+		return piv.SlotSignature, nil
+
+	case "9e":
+		return piv.SlotCardAuthentication, nil
+
+	case "9d":
+		return piv.SlotKeyManagement, nil
+
+	default:
+		return piv.Slot{}, fmt.Errorf("unknown slot type %q", slot)
+	}
+}
+
+func getPIVAlgorithm(algo Algorithm) (piv.Algorithm, bool) {
+	switch algo {
+	case AlgorithmEC256:
+		return piv.AlgorithmEC256, true
+
+	case AlgorithmEC384:
+		return piv.AlgorithmEC384, true
+
+	case AlgorithmEd25519:
+		return piv.AlgorithmEd25519, true
+
+	case AlgorithmRSA1024:
+		return piv.AlgorithmRSA1024, true
+
+	case AlgorithmRSA2048:
+		return piv.AlgorithmRSA2048, true
+
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !cgo && !windows
+
+package backend
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+)
+
+// YubiKey is a Backend that stores private keys and certificates in the PIV
+// slots of a YubiKey.
+//
+// This build of yubca was compiled without cgo, which is required to
+// communicate with a YubiKey on this platform.
+type YubiKey struct{}
+
+// NewYubiKey always returns an error, as this build of yubca was compiled
+// without cgo.
+func NewYubiKey(id int, pinProtected bool, auth *Auth) (*YubiKey, error) {
+	return nil, errors.New("yubikey support requires yubca to be built with cgo")
+}
+
+func (y *YubiKey) Slots() ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (y *YubiKey) Certificate(slot string) (*x509.Certificate, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (y *YubiKey) SetCertificate(slot string, cert *x509.Certificate) error {
+	return errors.ErrUnsupported
+}
+
+func (y *YubiKey) GenerateKey(slot string, algo Algorithm) (crypto.PublicKey, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (y *YubiKey) PublicKey(slot string) (crypto.PublicKey, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (y *YubiKey) PrivateKey(slot string, pub crypto.PublicKey) (crypto.Signer, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (y *YubiKey) Delete(slot string) error {
+	return errors.ErrUnsupported
+}
+
+func (y *YubiKey) Close() error {
+	return nil
+}
@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/jamescun/yubca/config"
+)
+
+// setNameConstraints adds the name constraints nc to cert, marked critical as
+// required by RFC 5280 Section 4.2.1.10.
+func setNameConstraints(cert *x509.Certificate, nc *config.NameConstraints) {
+	if nc == nil {
+		return
+	}
+
+	cert.PermittedDNSDomainsCritical = true
+
+	if p := nc.Permitted; p != nil {
+		cert.PermittedDNSDomains = p.DNS
+		cert.PermittedIPRanges = p.IPNets()
+		cert.PermittedEmailAddresses = p.Email
+		cert.PermittedURIDomains = p.URI
+	}
+
+	if e := nc.Excluded; e != nil {
+		cert.ExcludedDNSDomains = e.DNS
+		cert.ExcludedIPRanges = e.IPNets()
+		cert.ExcludedEmailAddresses = e.Email
+		cert.ExcludedURIDomains = e.URI
+	}
+}
+
+// checkPathLen verifies that ca may sign an intermediate with profile, without
+// exceeding its own path length.
+func checkPathLen(ca *x509.Certificate, name string, profile *config.Profile) error {
+	if !profile.CA || ca.MaxPathLen < 0 || (ca.MaxPathLen == 0 && !ca.MaxPathLenZero) {
+		return nil
+	}
+
+	if ca.MaxPathLen == 0 {
+		return fmt.Errorf("certificate authority has a path length of 0 and cannot sign intermediates")
+	}
+
+	if profile.MaxPathLen == nil || *profile.MaxPathLen >= ca.MaxPathLen {
+		return fmt.Errorf("profile %q must set maxPathLen below %d, the path length of the certificate authority", name, ca.MaxPathLen)
+	}
+
+	return nil
+}
+
+// checkNameConstraints verifies that the Subject Alternative Names requested
+// by csr are permitted, and not excluded, by the name constraints of ca.
+func checkNameConstraints(ca *x509.Certificate, csr *x509.CertificateRequest) error {
+	for _, dns := range csr.DNSNames {
+		err := checkName("dns name", dns, ca.PermittedDNSDomains, ca.ExcludedDNSDomains, matchDNS, excludesWildcard)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ip := range csr.IPAddresses {
+		if len(ca.PermittedIPRanges) > 0 && !anyIPNetContains(ca.PermittedIPRanges, ip) {
+			return fmt.Errorf("ip address %q is not permitted by the name constraints of the certificate authority", ip)
+		} else if anyIPNetContains(ca.ExcludedIPRanges, ip) {
+			return fmt.Errorf("ip address %q is excluded by the name constraints of the certificate authority", ip)
+		}
+	}
+
+	for _, email := range csr.EmailAddresses {
+		err := checkName("email", email, ca.PermittedEmailAddresses, ca.ExcludedEmailAddresses, matchEmail, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, uri := range csr.URIs {
+		err := checkName("uri", uri.String(), ca.PermittedURIDomains, ca.ExcludedURIDomains, matchURI, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkName verifies name matches one of permitted, if any, and none of
+// excluded. If wildcard is given, it also reports names that cover an
+// excluded constraint.
+func checkName(kind, name string, permitted, excluded []string, match func(name, constraint string) bool, wildcard func(name, constraint string) bool) error {
+	if len(permitted) > 0 && !anyMatch(name, permitted, match) {
+		return fmt.Errorf("%s %q is not permitted by the name constraints of the certificate authority", kind, name)
+	}
+
+	if anyMatch(name, excluded, match) || (wildcard != nil && anyMatch(name, excluded, wildcard)) {
+		return fmt.Errorf("%s %q is excluded by the name constraints of the certificate authority", kind, name)
+	}
+
+	return nil
+}
+
+func anyMatch(name string, constraints []string, match func(name, constraint string) bool) bool {
+	for _, constraint := range constraints {
+		if match(name, constraint) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func anyIPNetContains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchDNS reports whether the dns name is within the domain constraint, which
+// matches itself and its subdomains, or only its subdomains if it begins with
+// a period.
+func matchDNS(name, constraint string) bool {
+	name, constraint = normalizeDomain(name), normalizeDomain(constraint)
+
+	switch {
+	case constraint == "":
+		return true
+
+	case strings.HasPrefix(constraint, "."):
+		return strings.HasSuffix(name, constraint)
+
+	default:
+		return name == constraint || strings.HasSuffix(name, "."+constraint)
+	}
+}
+
+// excludesWildcard reports whether a wildcard dns name, such as *.example.org,
+// covers the excluded domain constraint.
+func excludesWildcard(name, constraint string) bool {
+	base, ok := strings.CutPrefix(normalizeDomain(name), "*.")
+	if !ok {
+		return false
+	}
+
+	return strings.HasSuffix(strings.TrimPrefix(normalizeDomain(constraint), "."), "."+base)
+}
+
+// matchHost reports whether host matches the constraint exactly, or is a
+// subdomain of it if it begins with a period.
+func matchHost(host, constraint string) bool {
+	host, constraint = normalizeDomain(host), normalizeDomain(constraint)
+
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+
+	return constraint == "" || host == constraint
+}
+
+// matchEmail reports whether the email matches the constraint, either a
+// mailbox or a host.
+func matchEmail(email, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+
+	_, host, ok := strings.Cut(email, "@")
+	return ok && matchHost(host, constraint)
+}
+
+// matchURI reports whether the host of uri matches the constraint. URIs
+// without a domain never match.
+func matchURI(uri, constraint string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Hostname() == "" || net.ParseIP(u.Hostname()) != nil {
+		return false
+	}
+
+	return matchHost(u.Hostname(), constraint)
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
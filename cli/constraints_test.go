@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/jamescun/yubca/config"
+)
+
+func TestMatchDNS(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint string
+		match      bool
+	}{
+		{"example.org", "example.org", true},
+		{"www.example.org", "example.org", true},
+		{"a.b.example.org", "example.org", true},
+		{"WWW.Example.ORG.", "example.org", true},
+		{"example.org", ".example.org", false},
+		{"www.example.org", ".example.org", true},
+		{"badexample.org", "example.org", false},
+		{"badexample.org", ".example.org", false},
+		{"example.org.evil", "example.org", false},
+		{"example.com", "example.org", false},
+		{"*.example.org", "example.org", true},
+		{"anything", "", true},
+	}
+
+	for _, test := range tests {
+		if match := matchDNS(test.name, test.constraint); match != test.match {
+			t.Errorf("matchDNS(%q, %q) = %v, expected %v", test.name, test.constraint, match, test.match)
+		}
+	}
+}
+
+func TestExcludesWildcard(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint string
+		match      bool
+	}{
+		{"*.example.org", "secret.example.org", true},
+		{"*.example.org", ".secret.example.org", true},
+		{"*.Example.org", "SECRET.example.org.", true},
+		{"*.example.org", "example.org", false},
+		{"*.example.org", "secret.example.com", false},
+		{"*.example.org", "secretexample.org", false},
+		{"www.example.org", "secret.example.org", false},
+	}
+
+	for _, test := range tests {
+		if match := excludesWildcard(test.name, test.constraint); match != test.match {
+			t.Errorf("excludesWildcard(%q, %q) = %v, expected %v", test.name, test.constraint, match, test.match)
+		}
+	}
+}
+
+func TestMatchEmail(t *testing.T) {
+	tests := []struct {
+		email      string
+		constraint string
+		match      bool
+	}{
+		{"admin@example.org", "admin@example.org", true},
+		{"Admin@Example.org", "admin@example.org", true},
+		{"other@example.org", "admin@example.org", false},
+		{"admin@example.org", "example.org", true},
+		{"admin@mail.example.org", "example.org", false},
+		{"admin@mail.example.org", ".example.org", true},
+		{"admin@example.org", ".example.org", false},
+		{"admin@badexample.org", ".example.org", false},
+		{"not-an-email", "example.org", false},
+	}
+
+	for _, test := range tests {
+		if match := matchEmail(test.email, test.constraint); match != test.match {
+			t.Errorf("matchEmail(%q, %q) = %v, expected %v", test.email, test.constraint, match, test.match)
+		}
+	}
+}
+
+func TestMatchURI(t *testing.T) {
+	tests := []struct {
+		uri        string
+		constraint string
+		match      bool
+	}{
+		{"https://example.org/path", "example.org", true},
+		{"https://user@example.org:8443/path", "example.org", true},
+		{"https://www.example.org", "example.org", false},
+		{"https://www.example.org", ".example.org", true},
+		{"https://example.org", ".example.org", false},
+		{"spiffe://example.org/workload", "example.org", true},
+		{"https://10.0.0.1/", "10.0.0.1", false},
+		{"urn:uuid:1234", "example.org", false},
+		{"://bad", "example.org", false},
+	}
+
+	for _, test := range tests {
+		if match := matchURI(test.uri, test.constraint); match != test.match {
+			t.Errorf("matchURI(%q, %q) = %v, expected %v", test.uri, test.constraint, match, test.match)
+		}
+	}
+}
+
+func TestCheckNameConstraints(t *testing.T) {
+	_, permittedIPs, _ := net.ParseCIDR("10.0.0.0/8")
+	_, excludedIPs, _ := net.ParseCIDR("10.1.0.0/16")
+
+	ca := &x509.Certificate{
+		PermittedDNSDomains:     []string{"example.org"},
+		ExcludedDNSDomains:      []string{"secret.example.org"},
+		PermittedIPRanges:       []*net.IPNet{permittedIPs},
+		ExcludedIPRanges:        []*net.IPNet{excludedIPs},
+		PermittedEmailAddresses: []string{"example.org"},
+		PermittedURIDomains:     []string{".example.org"},
+	}
+
+	tests := []struct {
+		name string
+		csr  *x509.CertificateRequest
+		ok   bool
+	}{
+		{"Empty", &x509.CertificateRequest{}, true},
+		{"PermittedDNS", &x509.CertificateRequest{DNSNames: []string{"www.example.org"}}, true},
+		{"UnpermittedDNS", &x509.CertificateRequest{DNSNames: []string{"www.example.org", "example.com"}}, false},
+		{"ExcludedDNS", &x509.CertificateRequest{DNSNames: []string{"db.secret.example.org"}}, false},
+		{"WildcardCoversExcluded", &x509.CertificateRequest{DNSNames: []string{"*.example.org"}}, false},
+		{"PermittedIP", &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.2.0.1")}}, true},
+		{"UnpermittedIP", &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("192.168.0.1")}}, false},
+		{"ExcludedIP", &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.1.0.1")}}, false},
+		{"PermittedEmail", &x509.CertificateRequest{EmailAddresses: []string{"admin@example.org"}}, true},
+		{"UnpermittedEmail", &x509.CertificateRequest{EmailAddresses: []string{"admin@example.com"}}, false},
+		{"PermittedURI", &x509.CertificateRequest{URIs: []*url.URL{{Scheme: "https", Host: "api.example.org"}}}, true},
+		{"UnpermittedURI", &x509.CertificateRequest{URIs: []*url.URL{{Scheme: "https", Host: "example.org"}}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkNameConstraints(ca, test.csr)
+			if test.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !test.ok && err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestCheckPathLen(t *testing.T) {
+	zero, one := 0, 1
+
+	tests := []struct {
+		name    string
+		ca      *x509.Certificate
+		profile *config.Profile
+		ok      bool
+	}{
+		{"NotCA", &x509.Certificate{MaxPathLen: 0, MaxPathLenZero: true}, &config.Profile{}, true},
+		{"Unlimited", &x509.Certificate{MaxPathLen: -1}, &config.Profile{CA: true}, true},
+		{"UnsetPathLen", &x509.Certificate{MaxPathLen: 0}, &config.Profile{CA: true}, true},
+		{"ZeroPathLen", &x509.Certificate{MaxPathLen: 0, MaxPathLenZero: true}, &config.Profile{CA: true, MaxPathLen: &zero}, false},
+		{"Below", &x509.Certificate{MaxPathLen: 1}, &config.Profile{CA: true, MaxPathLen: &zero}, true},
+		{"Equal", &x509.Certificate{MaxPathLen: 1}, &config.Profile{CA: true, MaxPathLen: &one}, false},
+		{"Missing", &x509.Certificate{MaxPathLen: 1}, &config.Profile{CA: true}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkPathLen(test.ca, "test", test.profile)
+			if test.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !test.ok && err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
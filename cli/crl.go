@@ -0,0 +1,338 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jamescun/yubca/backend"
+	"github.com/jamescun/yubca/config"
+	"github.com/jamescun/yubca/db"
+)
+
+// defaultCRLValidity is used when the configuration of a Certificate
+// Authority does not specify crlValidity.
+const defaultCRLValidity = 7 * 24 * time.Hour
+
+var (
+	crlOutputPath string
+	crlFormat     string
+	crlPartition  string
+	crlDelta      bool
+	crlBasePath   string
+)
+
+var generateCRL = &cobra.Command{
+	Use:   "crl",
+	Short: "generate a certificate revocation list",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		cfg, err := readConfig()
+		if err != nil {
+			return fmt.Errorf("could not read config: %w", err)
+		}
+
+		if issued == nil {
+			return fmt.Errorf("an issuance database is required, use --db")
+		}
+
+		if crlFormat != "pem" && crlFormat != "der" {
+			return fmt.Errorf("unknown format %q, expected pem or der", crlFormat)
+		}
+
+		if crlDelta && crlBasePath == "" {
+			return fmt.Errorf("a delta crl requires the complete crl it is based on, use --base")
+		}
+
+		// a partitioned crl only lists the certificates in the partition, and
+		// an issuing distribution point restricting its scope to them.
+		var (
+			deltaCRLURLs = cfg.DeltaCRL
+			partition    *config.CRLPartition
+		)
+		if crlPartition != "" {
+			var ok bool
+			partition, ok = cfg.CRLPartitions[crlPartition]
+			if !ok {
+				return fmt.Errorf("unknown crl partition %q", crlPartition)
+			}
+
+			deltaCRLURLs = partition.DeltaCRL
+		}
+
+		validity := defaultCRLValidity
+		if cfg.CRLValidity != "" {
+			validity, err = time.ParseDuration(cfg.CRLValidity)
+			if err != nil {
+				return fmt.Errorf("invalid crl validity duration: %w", err)
+			}
+		}
+
+		records, err := issued.Certificates(ctx)
+		if err != nil {
+			return fmt.Errorf("could not read issuance database: %w", err)
+		}
+
+		ca, err := openBackend(cfg)
+		if err != nil {
+			return fmt.Errorf("could not open backend: %w", err)
+		}
+		defer ca.Close()
+
+		caCert, err := ca.Certificate(cfg.Slot)
+		if errors.Is(err, backend.ErrNotFound) {
+			return fmt.Errorf("no certificate authority configured on slot %q", cfg.Slot)
+		} else if err != nil {
+			return fmt.Errorf("could not get certificate authority: %w", err)
+		}
+
+		caPrivateKey, err := ca.PrivateKey(cfg.Slot, caCert.PublicKey)
+		if err != nil {
+			return fmt.Errorf("could not get private key signer: %w", err)
+		}
+
+		var idp *pkix.Extension
+		if partition != nil {
+			ext, err := issuingDistributionPointExtension(partition.CRL)
+			if err != nil {
+				return fmt.Errorf("could not encode issuing distribution point: %w", err)
+			}
+
+			idp = &ext
+		}
+
+		var (
+			base        *x509.RevocationList
+			baseReasons = make(map[string]int)
+		)
+		if crlDelta {
+			base, err = readBaseCRL(crlBasePath, caCert, idp)
+			if err != nil {
+				return fmt.Errorf("could not read base crl: %w", err)
+			}
+
+			for _, entry := range base.RevokedCertificateEntries {
+				baseReasons[db.SerialString(entry.SerialNumber)] = entry.ReasonCode
+			}
+		}
+
+		// every scope shares one sequence of crl numbers, so the numbers of a
+		// partition have gaps where other scopes were generated, which RFC
+		// 5280 permits, and a delta is always numbered after its base.
+		number, err := issued.Increment(ctx, "crlnumber")
+		if err != nil {
+			return fmt.Errorf("could not increment crl number: %w", err)
+		}
+
+		now := time.Now()
+
+		template := &x509.RevocationList{
+			Number:     number,
+			ThisUpdate: now,
+			NextUpdate: now.Add(validity),
+		}
+
+		template.RevokedCertificateEntries, err = revokedEntries(records, func(serial *big.Int, record *db.Record) bool {
+			if partition != nil {
+				if name, _, ok := cfg.CRLPartition(serial, record.Profile); !ok || name != crlPartition {
+					return false
+				}
+			}
+
+			// a delta only lists revocations since its base, including a hold
+			// that has since become a permanent revocation.
+			reason, ok := baseReasons[db.SerialString(serial)]
+			return !ok || reason != record.Revocation.Reason
+		})
+		if err != nil {
+			return err
+		}
+
+		if base != nil {
+			template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, releasedHolds(base, records)...)
+		}
+
+		if idp != nil {
+			template.ExtraExtensions = append(template.ExtraExtensions, *idp)
+		}
+
+		if base != nil {
+			ext, err := deltaCRLIndicatorExtension(base.Number)
+			if err != nil {
+				return fmt.Errorf("could not encode delta crl indicator: %w", err)
+			}
+
+			template.ExtraExtensions = append(template.ExtraExtensions, ext)
+		} else if len(deltaCRLURLs) > 0 {
+			ext, err := freshestCRLExtension(deltaCRLURLs)
+			if err != nil {
+				return fmt.Errorf("could not encode freshest crl: %w", err)
+			}
+
+			template.ExtraExtensions = append(template.ExtraExtensions, ext)
+		}
+
+		crlBytes, err := x509.CreateRevocationList(rand.Reader, template, caCert, caPrivateKey)
+		if err != nil {
+			return fmt.Errorf("could not sign certificate revocation list: %w", err)
+		}
+
+		out := os.Stdout
+		if crlOutputPath != "" {
+			file, err := os.OpenFile(crlOutputPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+			if err != nil {
+				return fmt.Errorf("could not create certificate revocation list path: %w", err)
+			}
+			defer file.Close()
+
+			out = file
+		}
+
+		if crlFormat == "der" {
+			_, err = out.Write(crlBytes)
+		} else {
+			err = pem.Encode(out, &pem.Block{
+				Type:  "X509 CRL",
+				Bytes: crlBytes,
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("could not write certificate revocation list: %w", err)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	generateCRL.Flags().StringVar(&crlOutputPath, "output", "", "write certificate revocation list to a file instead of stdout")
+	generateCRL.Flags().StringVar(&crlFormat, "format", "pem", "encoding of certificate revocation list, pem or der")
+	generateCRL.Flags().StringVar(&crlPartition, "partition", "", "generate the certificate revocation list of a partition")
+	generateCRL.Flags().BoolVar(&crlDelta, "delta", false, "generate a delta of the certificate revocation list given by --base")
+	generateCRL.Flags().StringVar(&crlBasePath, "base", "", "path to the complete certificate revocation list a delta is based on")
+}
+
+// readBaseCRL reads the complete Certificate Revocation List at path that a
+// delta will be based on, which must have been signed by caCert and have the
+// same scope as the delta, given by its issuing distribution point idp, if
+// any.
+func readBaseCRL(path string, caCert *x509.Certificate, idp *pkix.Extension) (*x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("expected X509 CRL, got %q", block.Type)
+		}
+
+		data = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+
+	if !bytes.Equal(crl.RawIssuer, caCert.RawSubject) {
+		return nil, fmt.Errorf("issued by %q, not the certificate authority", crl.Issuer)
+	}
+
+	err = crl.CheckSignatureFrom(caCert)
+	if err != nil {
+		return nil, fmt.Errorf("not signed by certificate authority: %w", err)
+	}
+
+	var baseIDP *pkix.Extension
+	for i, ext := range crl.Extensions {
+		if ext.Id.Equal(oidExtensionDeltaCRLIndicator) {
+			return nil, fmt.Errorf("base must be a complete crl, not a delta")
+		} else if ext.Id.Equal(oidExtensionIssuingDistributionPoint) {
+			baseIDP = &crl.Extensions[i]
+		}
+	}
+
+	switch {
+	case idp == nil && baseIDP != nil:
+		return nil, fmt.Errorf("base is the crl of a partition, use --partition")
+
+	case idp != nil && baseIDP == nil:
+		return nil, fmt.Errorf("base is not the crl of this partition")
+
+	case idp != nil && !bytes.Equal(idp.Value, baseIDP.Value):
+		return nil, fmt.Errorf("base is the crl of a different partition")
+	}
+
+	if crl.Number == nil {
+		return nil, fmt.Errorf("base has no crl number")
+	}
+
+	return crl, nil
+}
+
+// releasedHolds returns the removeFromCRL entries of a delta for certificates
+// held by base that are no longer revoked in records.
+func releasedHolds(base *x509.RevocationList, records []*db.Record) []x509.RevocationListEntry {
+	released := make(map[string]bool)
+	for _, record := range records {
+		if record.Revocation == nil {
+			released[record.Serial] = true
+		}
+	}
+
+	var entries []x509.RevocationListEntry
+	for _, entry := range base.RevokedCertificateEntries {
+		if entry.ReasonCode != reasonCertificateHold || !released[db.SerialString(entry.SerialNumber)] {
+			continue
+		}
+
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   entry.SerialNumber,
+			RevocationTime: entry.RevocationTime,
+			ReasonCode:     reasonRemoveFromCRL,
+		})
+	}
+
+	return entries
+}
+
+// revokedEntries returns the revocation list entries for every revoked
+// certificate in records accepted by include.
+func revokedEntries(records []*db.Record, include func(*big.Int, *db.Record) bool) ([]x509.RevocationListEntry, error) {
+	var entries []x509.RevocationListEntry
+
+	for _, record := range records {
+		if record.Revocation == nil {
+			continue
+		}
+
+		serial, ok := new(big.Int).SetString(record.Serial, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial %q in issuance database", record.Serial)
+		}
+
+		if !include(serial, record) {
+			continue
+		}
+
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: record.Revocation.Time,
+			ReasonCode:     record.Revocation.Reason,
+		})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,287 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jamescun/yubca/db"
+)
+
+func TestCRL(t *testing.T) {
+	ca := newSoftwareCA(t, "")
+
+	tests := []struct {
+		name   string
+		reason string
+		code   int
+	}{
+		{"Unrevoked", "", 0},
+		{"Unspecified", "unspecified", 0},
+		{"KeyCompromise", "keyCompromise", 1},
+		{"Superseded", "superseded", 4},
+		{"CertificateHold", "certificateHold", 6},
+	}
+
+	revoked := make(map[string]int)
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cert := ca.sign("server")
+
+			if test.reason != "" {
+				ca.run("revoke", "--cert", ca.path("leaf.pem"), "--reason", test.reason)
+				revoked[db.SerialString(cert.SerialNumber)] = test.code
+			}
+
+			// every generation is numbered after the last, as clients
+			// compare the numbers of crls to find the latest.
+			crl := ca.crl()
+			if crl.Number.Cmp(big.NewInt(int64(i+1))) != 0 {
+				t.Errorf("expected crl number %d, got %s", i+1, crl.Number)
+			}
+
+			if n := len(crl.RevokedCertificateEntries); n != len(revoked) {
+				t.Fatalf("expected %d revoked certificates, got %d", len(revoked), n)
+			}
+
+			for _, entry := range crl.RevokedCertificateEntries {
+				code, ok := revoked[db.SerialString(entry.SerialNumber)]
+				if !ok {
+					t.Errorf("unexpected revoked serial %s", db.SerialString(entry.SerialNumber))
+				} else if entry.ReasonCode != code {
+					t.Errorf("expected reason %d for %s, got %d", code, db.SerialString(entry.SerialNumber), entry.ReasonCode)
+				}
+			}
+		})
+	}
+}
+
+func TestDeltaCRLBase(t *testing.T) {
+	ca := newSoftwareCA(t, `"crlPartitions": {
+		"web": { "crl": [ "http://example.org/web.crl" ], "profiles": [ "server" ] },
+		"device": { "crl": [ "http://example.org/device.crl" ], "profiles": [ "client" ] }
+	}`)
+
+	saveCRL(t, ca, "complete.crl")
+	saveCRL(t, ca, "web.crl", "--partition", "web")
+	saveCRL(t, ca, "delta.crl", "--delta", "--base", ca.path("complete.crl"))
+
+	other := newSoftwareCA(t, "")
+	saveCRL(t, other, "other.crl")
+
+	tests := []struct {
+		name      string
+		base      string
+		partition string
+		err       string
+	}{
+		{"Complete", ca.path("complete.crl"), "", ""},
+		{"Partition", ca.path("web.crl"), "web", ""},
+		{"Delta", ca.path("delta.crl"), "", "base must be a complete crl, not a delta"},
+		{"PartitionOfComplete", ca.path("web.crl"), "", "base is the crl of a partition, use --partition"},
+		{"CompleteOfPartition", ca.path("complete.crl"), "web", "base is not the crl of this partition"},
+		{"DifferentPartition", ca.path("web.crl"), "device", "base is the crl of a different partition"},
+		{"DifferentCA", other.path("other.crl"), "", "not signed by certificate authority"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ca.exec("crl", "--output", ca.path("ca.crl"), "--delta", "--base", test.base, "--partition", test.partition)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestDeltaCRL(t *testing.T) {
+	ca := newSoftwareCA(t, "")
+
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+		code   int
+	}{
+		{"Unrevoked", nil, nil, -1},
+		{"RevokedBeforeBase", []string{"--reason", "keyCompromise"}, nil, -1},
+		{"RevokedAfterBase", nil, []string{"--reason", "superseded"}, 4},
+		{"HeldAfterBase", nil, []string{"--reason", "certificateHold"}, 6},
+		{"HoldMadePermanent", []string{"--reason", "certificateHold"}, []string{"--reason", "keyCompromise"}, 1},
+		{"HoldReleased", []string{"--reason", "certificateHold"}, []string{"--release"}, 8},
+		{"HoldUnchanged", []string{"--reason", "certificateHold"}, nil, -1},
+	}
+
+	serials := make([]string, len(tests))
+	for i, test := range tests {
+		serials[i] = db.SerialString(ca.sign("server").SerialNumber)
+
+		if test.before != nil {
+			ca.run(append([]string{"revoke", "--serial", serials[i]}, test.before...)...)
+		}
+	}
+
+	base := saveCRL(t, ca, "base.crl")
+
+	for i, test := range tests {
+		if test.after != nil {
+			ca.run(append([]string{"revoke", "--serial", serials[i]}, test.after...)...)
+		}
+	}
+
+	delta := ca.crl("--delta", "--base", ca.path("base.crl"))
+
+	if delta.Number.Cmp(base.Number) <= 0 {
+		t.Errorf("expected delta number after base %s, got %s", base.Number, delta.Number)
+	}
+
+	ext, err := deltaCRLIndicatorExtension(base.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasExtension(delta, ext) {
+		t.Errorf("expected delta crl indicator of base %s", base.Number)
+	}
+
+	entries := make(map[string]x509.RevocationListEntry)
+	for _, entry := range delta.RevokedCertificateEntries {
+		entries[db.SerialString(entry.SerialNumber)] = entry
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry, ok := entries[serials[i]]
+			if test.code < 0 {
+				if ok {
+					t.Errorf("expected no entry, got reason %d", entry.ReasonCode)
+				}
+				return
+			}
+
+			if !ok {
+				t.Fatalf("expected entry with reason %d", test.code)
+			} else if entry.ReasonCode != test.code {
+				t.Errorf("expected reason %d, got %d", test.code, entry.ReasonCode)
+			}
+		})
+	}
+}
+
+func TestCRLPartitions(t *testing.T) {
+	ca := newSoftwareCA(t, `"crl": [ "http://example.org/ca.crl" ],
+		"crlPartitions": {
+			"web": { "crl": [ "http://example.org/web.crl" ], "profiles": [ "server" ] },
+			"device": { "crl": [ "http://example.org/device.crl" ], "profiles": [ "client" ] }
+		}`)
+
+	revoked := make(map[string]string)
+	for _, profile := range []string{"server", "server", "client", "ocsp"} {
+		cert := ca.sign(profile)
+		ca.run("revoke", "--cert", ca.path("leaf.pem"))
+		revoked[db.SerialString(cert.SerialNumber)] = profile
+	}
+
+	tests := []struct {
+		name      string
+		partition string
+		crl       string
+		profiles  []string
+	}{
+		{"Complete", "", "", []string{"server", "client", "ocsp"}},
+		{"Web", "web", "http://example.org/web.crl", []string{"server"}},
+		{"Device", "device", "http://example.org/device.crl", []string{"client"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			crl := ca.crl("--partition", test.partition)
+
+			var expected int
+			for _, profile := range revoked {
+				for _, p := range test.profiles {
+					if p == profile {
+						expected++
+					}
+				}
+			}
+
+			if n := len(crl.RevokedCertificateEntries); n != expected {
+				t.Errorf("expected %d revoked certificates, got %d", expected, n)
+			}
+
+			for _, entry := range crl.RevokedCertificateEntries {
+				profile := revoked[db.SerialString(entry.SerialNumber)]
+
+				found := false
+				for _, p := range test.profiles {
+					found = found || p == profile
+				}
+
+				if !found {
+					t.Errorf("unexpected revoked certificate of profile %q", profile)
+				}
+			}
+
+			// a partition is scoped by its issuing distribution point, and the
+			// complete crl has none.
+			if test.partition != "" {
+				ext, err := issuingDistributionPointExtension([]string{test.crl})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !hasExtension(crl, ext) {
+					t.Errorf("expected issuing distribution point of %s", test.crl)
+				}
+			} else {
+				for _, ext := range crl.Extensions {
+					if ext.Id.Equal(oidExtensionIssuingDistributionPoint) {
+						t.Errorf("unexpected issuing distribution point")
+					}
+				}
+			}
+		})
+	}
+
+	err := ca.exec("crl", "--output", ca.path("ca.crl"), "--partition", "unknown")
+	if err == nil || !strings.Contains(err.Error(), `unknown crl partition "unknown"`) {
+		t.Errorf("expected unknown partition error, got %v", err)
+	}
+}
+
+// saveCRL generates a Certificate Revocation List of ca with any further flags
+// given in args, keeping it as name.
+func saveCRL(t *testing.T, ca *softwareCA, name string, args ...string) *x509.RevocationList {
+	t.Helper()
+
+	crl := ca.crl(args...)
+
+	err := os.Rename(ca.path("ca.crl"), ca.path(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return crl
+}
+
+// hasExtension reports whether crl has an extension equal to ext.
+func hasExtension(crl *x509.RevocationList, ext pkix.Extension) bool {
+	for _, e := range crl.Extensions {
+		if e.Id.Equal(ext.Id) && e.Critical == ext.Critical && bytes.Equal(e.Value, ext.Value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,480 @@
+package cli
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jamescun/yubca/backend"
+	"github.com/jamescun/yubca/db"
+)
+
+var (
+	dbFormat string
+
+	querySerial        string
+	queryCommonName    string
+	queryDNSName       string
+	queryExpiresAfter  string
+	queryExpiresBefore string
+	queryStatus        string
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "query the issuance database",
+}
+
+var listRecords = &cobra.Command{
+	Use:   "list",
+	Short: "list the certificates in the issuance database",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return searchIssued(cmd, "")
+	},
+}
+
+var searchRecords = &cobra.Command{
+	Use:   "search TERM",
+	Short: "search for certificates by serial, common name or dns name",
+	Args:  cobra.ExactArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return searchIssued(cmd, args[0])
+	},
+}
+
+var showRecord = &cobra.Command{
+	Use:   "show SERIAL",
+	Short: "show a certificate in the issuance database",
+	Args:  cobra.ExactArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		if issued == nil {
+			return fmt.Errorf("an issuance database is required, use --db")
+		}
+
+		if dbFormat != "table" && dbFormat != "json" && dbFormat != "pem" {
+			return fmt.Errorf("unknown format %q, expected table, json or pem", dbFormat)
+		}
+
+		serial, err := parseSerial(args[0])
+		if err != nil {
+			return err
+		}
+
+		record, err := issued.Certificate(ctx, serial)
+		if errors.Is(err, db.ErrNotFound) {
+			return fmt.Errorf("certificate %s not found in issuance database", db.SerialString(serial))
+		} else if err != nil {
+			return fmt.Errorf("could not get certificate from issuance database: %w", err)
+		}
+
+		switch dbFormat {
+		case "json":
+			return writeJSON(os.Stdout, record)
+
+		case "pem":
+			if len(record.Certificate) == 0 {
+				return fmt.Errorf("certificate %s was recorded without its certificate", record.Serial)
+			}
+
+			return pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: record.Certificate})
+		}
+
+		printRecord(os.Stdout, record, time.Now())
+
+		return nil
+	},
+}
+
+var migrateDB = &cobra.Command{
+	Use:   "migrate DESTINATION",
+	Short: "copy the issuance database to a new database, such as sqlite:issuance.db",
+	Args:  cobra.ExactArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		if issued == nil {
+			return fmt.Errorf("an issuance database is required, use --db")
+		}
+
+		records, err := issued.Certificates(ctx)
+		if err != nil {
+			return fmt.Errorf("could not read issuance database: %w", err)
+		}
+
+		counters, err := issued.Counters(ctx)
+		if err != nil {
+			return fmt.Errorf("could not read issuance database counters: %w", err)
+		}
+
+		dest, err := openDB(args[0])
+		if err != nil {
+			return fmt.Errorf("could not open destination database: %w", err)
+		}
+		defer dest.Close()
+
+		importer, ok := dest.(db.Importer)
+		if !ok {
+			return fmt.Errorf("destination database does not support migration, use sqlite:PATH")
+		}
+
+		existing, err := dest.Certificates(ctx)
+		if err != nil {
+			return fmt.Errorf("could not read destination database: %w", err)
+		} else if len(existing) > 0 {
+			return fmt.Errorf("destination database already contains %d certificates", len(existing))
+		}
+
+		err = importer.Import(ctx, records, counters)
+		if err != nil {
+			return fmt.Errorf("could not import into destination database: %w", err)
+		}
+
+		fmt.Printf("Migrated %d certificates and %d counters to %s.\n", len(records), len(counters), args[0])
+
+		return nil
+	},
+}
+
+var (
+	reconcilePending bool
+	pendingTimeout   time.Duration
+)
+
+var verifyDB = &cobra.Command{
+	Use:   "verify",
+	Short: "verify the issuance database has not been tampered with",
+	Long: `Verify the issuance database has not been tampered with, and report serials
+left pending by signing that was interrupted before the certificate was recorded.
+
+With --reconcile, serials pending for longer than --pending-timeout are marked as
+abandoned. If the certificate was in fact released, it should be revoked by its
+serial instead.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		cfg, err := readConfig()
+		if err != nil {
+			return fmt.Errorf("could not read config: %w", err)
+		}
+
+		if issued == nil {
+			return fmt.Errorf("an issuance database is required, use --db")
+		}
+
+		if verifier, ok := issued.(db.Verifier); ok {
+			caCert, err := readCACertificate(cfg)
+			if err != nil {
+				return err
+			}
+
+			v, err := verifier.Verify(ctx, caCert)
+			if err != nil {
+				return fmt.Errorf("verification failed: %w", err)
+			}
+
+			fmt.Printf("Verified %d entries and %d checkpoints.\n", v.Entries, v.Checkpoints)
+
+			if v.Unchained > 0 {
+				fmt.Printf("Warning: %d entries were written before chaining and cannot be verified.\n", v.Unchained)
+			}
+
+			if v.Uncheckpointed > 0 {
+				fmt.Printf("Warning: %d entries were written after the last checkpoint, run `yubca db checkpoint` to sign them.\n", v.Uncheckpointed)
+			}
+		} else {
+			fmt.Println("Issuance database is not chained, only pending serials are checked.")
+		}
+
+		pending, err := issued.Search(ctx, &db.Query{Status: db.StatusPending})
+		if err != nil {
+			return fmt.Errorf("could not search issuance database: %w", err)
+		}
+
+		now := time.Now()
+
+		for _, record := range pending {
+			if !reconcilePending || now.Sub(record.Reservation.Time) < pendingTimeout {
+				fmt.Printf("Warning: serial %s has been pending since %s.\n", record.Serial, formatTime(record.Reservation.Time))
+				continue
+			}
+
+			serial, err := parseSerial(record.Serial)
+			if err != nil {
+				return err
+			}
+
+			err = issued.Abandon(ctx, serial)
+			if err != nil {
+				return fmt.Errorf("could not abandon serial %s: %w", record.Serial, err)
+			}
+
+			fmt.Printf("Abandoned serial %s pending since %s.\n", record.Serial, formatTime(record.Reservation.Time))
+		}
+
+		if len(pending) > 0 && !reconcilePending {
+			fmt.Println("Run `yubca db verify --reconcile` to abandon pending serials once signing is known to have been interrupted.")
+		}
+
+		return nil
+	},
+}
+
+var checkpointDB = &cobra.Command{
+	Use:   "checkpoint",
+	Short: "sign the current state of the issuance database",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := readConfig()
+		if err != nil {
+			return fmt.Errorf("could not read config: %w", err)
+		}
+
+		verifier, err := getVerifier()
+		if err != nil {
+			return err
+		}
+
+		ca, err := openBackend(cfg)
+		if err != nil {
+			return fmt.Errorf("could not open backend: %w", err)
+		}
+		defer ca.Close()
+
+		caCert, err := ca.Certificate(cfg.Slot)
+		if errors.Is(err, backend.ErrNotFound) {
+			return fmt.Errorf("no certificate authority configured on slot %q", cfg.Slot)
+		} else if err != nil {
+			return fmt.Errorf("could not get certificate authority: %w", err)
+		}
+
+		caPrivateKey, err := ca.PrivateKey(cfg.Slot, caCert.PublicKey)
+		if err != nil {
+			return fmt.Errorf("could not get private key signer: %w", err)
+		}
+
+		cp, err := verifier.Checkpoint(cmd.Context(), caPrivateKey)
+		if err != nil {
+			return fmt.Errorf("could not checkpoint issuance database: %w", err)
+		}
+
+		fmt.Printf("Signed checkpoint of %d entries.\n", cp.Entries)
+
+		return nil
+	},
+}
+
+// getVerifier returns the issuance database if it supports verification.
+func getVerifier() (db.Verifier, error) {
+	if issued == nil {
+		return nil, fmt.Errorf("an issuance database is required, use --db")
+	}
+
+	verifier, ok := issued.(db.Verifier)
+	if !ok {
+		return nil, fmt.Errorf("issuance database does not support verification, only json databases are chained")
+	}
+
+	return verifier, nil
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{listRecords, searchRecords} {
+		cmd.Flags().StringVar(&dbFormat, "format", "table", "output format, table or json")
+	}
+
+	showRecord.Flags().StringVar(&dbFormat, "format", "table", "output format, table, json or pem to export the certificate")
+
+	for _, cmd := range []*cobra.Command{listRecords, searchRecords} {
+		cmd.Flags().StringVar(&querySerial, "serial", "", "only certificates whose hex-encoded serial begins with this")
+		cmd.Flags().StringVar(&queryCommonName, "cn", "", "only certificates whose common name contains this")
+		cmd.Flags().StringVar(&queryDNSName, "dns", "", "only certificates with a dns name containing this")
+		cmd.Flags().StringVar(&queryExpiresAfter, "expires-after", "", "only certificates expiring after an RFC 3339 time or duration from now")
+		cmd.Flags().StringVar(&queryExpiresBefore, "expires-before", "", "only certificates expiring before an RFC 3339 time or duration from now")
+		cmd.Flags().StringVar(&queryStatus, "status", "", "only certificates that are valid, expired, revoked, pending or abandoned")
+	}
+
+	verifyDB.Flags().BoolVar(&reconcilePending, "reconcile", false, "abandon serials pending for longer than --pending-timeout")
+	verifyDB.Flags().DurationVar(&pendingTimeout, "pending-timeout", 10*time.Minute, "how long a serial may be pending before it is reconciled")
+
+	dbCmd.AddCommand(listRecords)
+	dbCmd.AddCommand(searchRecords)
+	dbCmd.AddCommand(showRecord)
+	dbCmd.AddCommand(migrateDB)
+	dbCmd.AddCommand(verifyDB)
+	dbCmd.AddCommand(checkpointDB)
+}
+
+// searchIssued prints the records in the issuance database matching text and
+// the query flags.
+func searchIssued(cmd *cobra.Command, text string) error {
+	ctx := cmd.Context()
+
+	if issued == nil {
+		return fmt.Errorf("an issuance database is required, use --db")
+	}
+
+	if dbFormat != "table" && dbFormat != "json" {
+		return fmt.Errorf("unknown format %q, expected table or json", dbFormat)
+	}
+
+	query, err := getQuery(text)
+	if err != nil {
+		return err
+	}
+
+	records, err := issued.Search(ctx, query)
+	if err != nil {
+		return fmt.Errorf("could not search issuance database: %w", err)
+	}
+
+	if dbFormat == "json" {
+		if records == nil {
+			records = []*db.Record{}
+		}
+
+		return writeJSON(os.Stdout, records)
+	}
+
+	now := time.Now()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIAL\tCOMMON NAME\tNOT AFTER\tSTATUS\tPROFILE")
+
+	for _, record := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", record.Serial, record.CommonName, formatTime(record.NotAfter), record.Status(now), record.Profile)
+	}
+
+	return tw.Flush()
+}
+
+func getQuery(text string) (*db.Query, error) {
+	query := &db.Query{
+		Text:       text,
+		Serial:     querySerial,
+		CommonName: queryCommonName,
+		DNSName:    queryDNSName,
+	}
+
+	var err error
+
+	if queryExpiresAfter != "" {
+		query.ExpiresAfter, err = parseTimeOrDuration(queryExpiresAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --expires-after: %w", err)
+		}
+	}
+
+	if queryExpiresBefore != "" {
+		query.ExpiresBefore, err = parseTimeOrDuration(queryExpiresBefore)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --expires-before: %w", err)
+		}
+	}
+
+	switch queryStatus {
+	case "", db.StatusValid, db.StatusExpired, db.StatusRevoked, db.StatusPending, db.StatusAbandoned:
+		query.Status = queryStatus
+
+	default:
+		return nil, fmt.Errorf("unknown status %q, expected valid, expired, revoked, pending or abandoned", queryStatus)
+	}
+
+	return query, nil
+}
+
+// parseTimeOrDuration parses str as an RFC 3339 time, or a duration relative
+// to now such as 720h.
+func parseTimeOrDuration(str string) (time.Time, error) {
+	if d, err := time.ParseDuration(str); err == nil {
+		return time.Now().Add(d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 time or duration, got %q", str)
+	}
+
+	return t, nil
+}
+
+func printRecord(w io.Writer, record *db.Record, now time.Time) {
+	fmt.Fprintf(w, "Serial:         %s\nFingerprint:    %s\n", record.Serial, record.Fingerprint)
+	fmt.Fprintf(w, "Common Name:    %s\n", record.CommonName)
+	fmt.Fprintf(w, "Not Before:     %s\nNot After:      %s\n", formatTime(record.NotBefore), formatTime(record.NotAfter))
+
+	if record.IssuerKeyID != "" {
+		fmt.Fprintf(w, "AuthorityKeyID: %s\n", record.IssuerKeyID)
+	}
+
+	if record.Profile != "" {
+		fmt.Fprintf(w, "Profile:        %s\n", record.Profile)
+	}
+
+	if record.Requester != "" {
+		fmt.Fprintf(w, "Requester:      %s\n", record.Requester)
+	}
+
+	if record.Operator != "" {
+		fmt.Fprintf(w, "Operator:       %s\n", record.Operator)
+	}
+
+	if record.CSRFingerprint != "" {
+		fmt.Fprintf(w, "CSR:            %s\n", record.CSRFingerprint)
+	}
+
+	fmt.Fprintf(w, "Status:         %s\n", record.Status(now))
+
+	if record.Reservation != nil {
+		fmt.Fprintf(w, "Reserved At:    %s\n", formatTime(record.Reservation.Time))
+	}
+
+	if record.Revocation != nil {
+		fmt.Fprintf(w, "Revoked At:     %s\nReason:         %s\n", formatTime(record.Revocation.Time), revocationReasonName(record.Revocation.Reason))
+	}
+
+	printList(w, "Key Usage", record.KeyUsage)
+	printList(w, "Ext Key Usage", record.ExtKeyUsage)
+	printList(w, "DNS Names", record.DNSNames)
+	printList(w, "IP Addresses", record.IPAddresses)
+	printList(w, "Emails", record.EmailAddresses)
+	printList(w, "URIs", record.URIs)
+}
+
+func printList(w io.Writer, name string, values []string) {
+	if len(values) > 0 {
+		fmt.Fprintf(w, "%s:\n", name)
+
+		for _, value := range values {
+			fmt.Fprintf(w, "  %s\n", value)
+		}
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jamescun/yubca/backend"
+)
+
+var deleteConfirmed bool
+
+var deleteCA = &cobra.Command{
+	Use:   "delete",
+	Short: "delete certificate authority and destroy its private key",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := readConfig()
+		if err != nil {
+			return fmt.Errorf("could not read config: %w", err)
+		}
+
+		ca, err := openBackend(cfg)
+		if err != nil {
+			return fmt.Errorf("could not open backend: %w", err)
+		}
+		defer ca.Close()
+
+		cert, err := ca.Certificate(cfg.Slot)
+		if errors.Is(err, backend.ErrNotFound) {
+			return fmt.Errorf("no certificate authority configured on slot %q", cfg.Slot)
+		} else if err != nil {
+			return fmt.Errorf("could not get certificate authority: %w", err)
+		}
+
+		printCertificate(os.Stdout, cert)
+		fmt.Println()
+
+		if !deleteConfirmed {
+			fmt.Printf("This will permanently destroy the certificate authority above.\nType its common name %q to confirm: ", cert.Subject.CommonName)
+
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil {
+				return fmt.Errorf("stdin: %w", err)
+			}
+
+			if strings.TrimSpace(line) != cert.Subject.CommonName {
+				return fmt.Errorf("confirmation did not match, certificate authority not deleted")
+			}
+		}
+
+		err = ca.Delete(cfg.Slot)
+		if err != nil {
+			return fmt.Errorf("could not delete certificate authority on slot %q: %w", cfg.Slot, err)
+		}
+
+		fmt.Println("Done!")
+
+		return nil
+	},
+}
+
+func init() {
+	deleteCA.Flags().BoolVar(&deleteConfirmed, "yes", false, "delete without prompting for confirmation")
+}
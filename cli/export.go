@@ -7,8 +7,9 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/go-piv/piv-go/piv"
 	"github.com/spf13/cobra"
+
+	"github.com/jamescun/yubca/backend"
 )
 
 var (
@@ -26,14 +27,17 @@ var export = &cobra.Command{
 			return fmt.Errorf("could not read config: %w", err)
 		}
 
-		slot, ok := getSlot(cfg.Slot)
-		if !ok {
-			return fmt.Errorf("unknown slot type %q", cfg.Slot)
+		ca, err := openBackend(cfg)
+		if err != nil {
+			return fmt.Errorf("could not open backend: %w", err)
 		}
+		defer ca.Close()
 
-		cert, err := key.Certificate(slot)
-		if errors.Is(err, piv.ErrNotFound) {
+		cert, err := ca.Certificate(cfg.Slot)
+		if errors.Is(err, backend.ErrNotFound) {
 			return fmt.Errorf("no certificate authority configured on slot %q", cfg.Slot)
+		} else if err != nil {
+			return fmt.Errorf("could not get certificate authority: %w", err)
 		}
 
 		if exportCA {
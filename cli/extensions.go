@@ -0,0 +1,232 @@
+package cli
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/jamescun/yubca/config"
+)
+
+var (
+	oidExtensionDeltaCRLIndicator        = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidExtensionIssuingDistributionPoint = asn1.ObjectIdentifier{2, 5, 29, 28}
+	oidExtensionFreshestCRL              = asn1.ObjectIdentifier{2, 5, 29, 46}
+	oidExtensionOCSPNoCheck              = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+	oidExtensionCertificatePolicies      = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidExtensionPolicyConstraints        = asn1.ObjectIdentifier{2, 5, 29, 36}
+	oidExtensionInhibitAnyPolicy         = asn1.ObjectIdentifier{2, 5, 29, 54}
+
+	oidPolicyQualifierCPS        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	oidPolicyQualifierUserNotice = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+)
+
+// RFC 5280 Section 4.2.1.13
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+}
+
+type distributionPointName struct {
+	FullName []asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// RFC 5280 Section 5.2.5
+type issuingDistributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+}
+
+// RFC 5280 Section 4.2.1.4
+type policyInformation struct {
+	PolicyIdentifier asn1.ObjectIdentifier
+	PolicyQualifiers []policyQualifierInfo `asn1:"optional,omitempty"`
+}
+
+type policyQualifierInfo struct {
+	PolicyQualifierID asn1.ObjectIdentifier
+	Qualifier         asn1.RawValue
+}
+
+type userNotice struct {
+	NoticeRef    noticeReference `asn1:"optional"`
+	ExplicitText string          `asn1:"optional,utf8"`
+}
+
+type noticeReference struct {
+	Organization  string `asn1:"utf8"`
+	NoticeNumbers []int
+}
+
+// uriGeneralNames returns urls as the uniformResourceIdentifier choice of
+// GeneralName, defined by RFC 5280 Section 4.2.1.6.
+func uriGeneralNames(urls []string) []asn1.RawValue {
+	names := make([]asn1.RawValue, len(urls))
+	for i, url := range urls {
+		names[i] = asn1.RawValue{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(url)}
+	}
+
+	return names
+}
+
+// freshestCRLExtension returns the Freshest CRL extension pointing to the
+// delta Certificate Revocation Lists published at urls.
+func freshestCRLExtension(urls []string) (pkix.Extension, error) {
+	value, err := asn1.Marshal([]distributionPoint{{
+		DistributionPoint: distributionPointName{FullName: uriGeneralNames(urls)},
+	}})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{Id: oidExtensionFreshestCRL, Value: value}, nil
+}
+
+// issuingDistributionPointExtension returns the Issuing Distribution Point
+// extension limiting the scope of a Certificate Revocation List to the
+// certificates whose CRL Distribution Points are urls.
+func issuingDistributionPointExtension(urls []string) (pkix.Extension, error) {
+	value, err := asn1.Marshal(issuingDistributionPoint{
+		DistributionPoint: distributionPointName{FullName: uriGeneralNames(urls)},
+	})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{Id: oidExtensionIssuingDistributionPoint, Critical: true, Value: value}, nil
+}
+
+// deltaCRLIndicatorExtension returns the Delta CRL Indicator extension
+// identifying a Certificate Revocation List as a delta of the complete list
+// numbered base.
+func deltaCRLIndicatorExtension(base *big.Int) (pkix.Extension, error) {
+	value, err := asn1.Marshal(base)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{Id: oidExtensionDeltaCRLIndicator, Critical: true, Value: value}, nil
+}
+
+// ocspNoCheckExtension returns the OCSP No Check extension, indicating the
+// certificate of a delegated OCSP responder should not itself be checked for
+// revocation, defined by RFC 6960 Section 4.2.2.2.1.
+func ocspNoCheckExtension() pkix.Extension {
+	return pkix.Extension{Id: oidExtensionOCSPNoCheck, Value: asn1.NullBytes}
+}
+
+// certificatePoliciesExtension returns the Certificate Policies extension
+// listing policies and their qualifiers.
+func certificatePoliciesExtension(policies []*config.Policy) (pkix.Extension, error) {
+	infos := make([]policyInformation, len(policies))
+	for i, policy := range policies {
+		infos[i].PolicyIdentifier = policy.OID()
+
+		for _, cps := range policy.CPS {
+			qualifier, err := asn1.MarshalWithParams(cps, "ia5")
+			if err != nil {
+				return pkix.Extension{}, err
+			}
+
+			infos[i].PolicyQualifiers = append(infos[i].PolicyQualifiers, policyQualifierInfo{
+				PolicyQualifierID: oidPolicyQualifierCPS,
+				Qualifier:         asn1.RawValue{FullBytes: qualifier},
+			})
+		}
+
+		if notice := policy.UserNotice; notice != nil {
+			un := userNotice{ExplicitText: notice.ExplicitText}
+			if notice.Organization != "" {
+				un.NoticeRef = noticeReference{Organization: notice.Organization, NoticeNumbers: notice.NoticeNumbers}
+			}
+
+			qualifier, err := asn1.Marshal(un)
+			if err != nil {
+				return pkix.Extension{}, err
+			}
+
+			infos[i].PolicyQualifiers = append(infos[i].PolicyQualifiers, policyQualifierInfo{
+				PolicyQualifierID: oidPolicyQualifierUserNotice,
+				Qualifier:         asn1.RawValue{FullBytes: qualifier},
+			})
+		}
+	}
+
+	value, err := asn1.Marshal(infos)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{Id: oidExtensionCertificatePolicies, Value: value}, nil
+}
+
+// policyConstraintsExtension returns the Policy Constraints extension, defined
+// by RFC 5280 Section 4.2.1.11, requiring an explicit policy or inhibiting
+// policy mapping after the given number of certificates. Either may be nil.
+func policyConstraintsExtension(requireExplicitPolicy, inhibitPolicyMapping *int) (pkix.Extension, error) {
+	var constraints []byte
+
+	for tag, skipCerts := range []*int{requireExplicitPolicy, inhibitPolicyMapping} {
+		if skipCerts == nil {
+			continue
+		}
+
+		value, err := asn1.MarshalWithParams(*skipCerts, fmt.Sprintf("tag:%d", tag))
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+
+		constraints = append(constraints, value...)
+	}
+
+	value, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: constraints})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{Id: oidExtensionPolicyConstraints, Critical: true, Value: value}, nil
+}
+
+// inhibitAnyPolicyExtension returns the Inhibit anyPolicy extension, defined
+// by RFC 5280 Section 4.2.1.14, after skipCerts certificates.
+func inhibitAnyPolicyExtension(skipCerts int) (pkix.Extension, error) {
+	value, err := asn1.Marshal(skipCerts)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{Id: oidExtensionInhibitAnyPolicy, Critical: true, Value: value}, nil
+}
+
+// subjectKeyID returns the Subject Key Identifier of pub, computed by method
+// from the bits of its subjectPublicKey. sha1 is method 1 of RFC 5280 Section
+// 4.2.1.2, and sha256 is method 1 of RFC 7093 Section 2.
+func subjectKeyID(pub crypto.PublicKey, method string) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+
+	_, err = asn1.Unmarshal(der, &spki)
+	if err != nil {
+		return nil, err
+	}
+
+	switch method {
+	case config.KeyIDSHA256:
+		sum := sha256.Sum256(spki.SubjectPublicKey.Bytes)
+		return sum[:20], nil
+
+	default:
+		sum := sha1.Sum(spki.SubjectPublicKey.Bytes)
+		return sum[:], nil
+	}
+}
@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/jamescun/yubca/config"
+)
+
+func TestPolicyConstraintsExtension(t *testing.T) {
+	zero, one, two := 0, 1, 2
+
+	tests := []struct {
+		name            string
+		requireExplicit *int
+		inhibitMapping  *int
+		der             string
+	}{
+		{"Both", &zero, &two, "3006800100810102"},
+		{"RequireExplicitPolicy", &two, nil, "3003800102"},
+		{"InhibitPolicyMapping", nil, &one, "3003810101"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ext, err := policyConstraintsExtension(test.requireExplicit, test.inhibitMapping)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			assertExtension(t, ext, oidExtensionPolicyConstraints, true, test.der)
+		})
+	}
+}
+
+func TestInhibitAnyPolicyExtension(t *testing.T) {
+	ext, err := inhibitAnyPolicyExtension(0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	assertExtension(t, ext, oidExtensionInhibitAnyPolicy, true, "020100")
+}
+
+func TestCertificatePoliciesExtension(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []*config.Policy
+		der      string
+	}{
+		{
+			name:     "Policy",
+			policies: []*config.Policy{{ID: "1.2.3"}},
+			der:      "3006" + "3004" + "06022a03",
+		},
+		{
+			name:     "AnyPolicyWithCPS",
+			policies: []*config.Policy{{ID: "any", CPS: []string{"http://a/"}}},
+			der: "3021" + "301f" + "0604551d2000" +
+				"3017" + "3015" + "06082b06010505070201" + "1609687474703a2f2f612f",
+		},
+		{
+			name:     "UserNoticeExplicitText",
+			policies: []*config.Policy{{ID: "1.2.3", UserNotice: &config.UserNotice{ExplicitText: "Hi", NoticeNumbers: []int{}}}},
+			der: "301a" + "3018" + "06022a03" +
+				"3012" + "3010" + "06082b06010505070202" + "3004" + "0c024869",
+		},
+		{
+			name:     "UserNoticeReference",
+			policies: []*config.Policy{{ID: "1.2.3", UserNotice: &config.UserNotice{Organization: "Org", NoticeNumbers: []int{1, 2}, ExplicitText: "Hi"}}},
+			der: "3029" + "3027" + "06022a03" +
+				"3021" + "301f" + "06082b06010505070202" +
+				"3013" + "300d" + "0c034f7267" + "3006020101020102" + "0c024869",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ext, err := certificatePoliciesExtension(test.policies)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			assertExtension(t, ext, oidExtensionCertificatePolicies, false, test.der)
+		})
+	}
+}
+
+func TestIssuingDistributionPointExtension(t *testing.T) {
+	ext, err := issuingDistributionPointExtension([]string{"http://a/"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	assertExtension(t, ext, oidExtensionIssuingDistributionPoint, true, "300f"+"a00d"+"a00b"+"8609687474703a2f2f612f")
+}
+
+func TestFreshestCRLExtension(t *testing.T) {
+	ext, err := freshestCRLExtension([]string{"http://a/", "http://b/"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	assertExtension(t, ext, oidExtensionFreshestCRL, false, "301c"+"301a"+"a018"+"a016"+"8609687474703a2f2f612f"+"8609687474703a2f2f622f")
+}
+
+func TestSubjectKeyID(t *testing.T) {
+	pub := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public()
+
+	tests := []struct {
+		name   string
+		method string
+		id     string
+	}{
+		{"Default", "", "8c30c97e7fd5460ce3b962db4cd75879eecd8abd"},
+		{"SHA1", config.KeyIDSHA1, "8c30c97e7fd5460ce3b962db4cd75879eecd8abd"},
+		{"SHA256", config.KeyIDSHA256, "139e3940e64b5491722088d9a0d741628fc826e0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := subjectKeyID(pub, test.method)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got := hex.EncodeToString(id); got != test.id {
+				t.Errorf("expected key id %s, got %s", test.id, got)
+			}
+		})
+	}
+}
+
+func assertExtension(t *testing.T, ext pkix.Extension, id asn1.ObjectIdentifier, critical bool, der string) {
+	t.Helper()
+
+	if !ext.Id.Equal(id) {
+		t.Errorf("expected id %s, got %s", id, ext.Id)
+	}
+
+	if ext.Critical != critical {
+		t.Errorf("expected critical %v, got %v", critical, ext.Critical)
+	}
+
+	if got := hex.EncodeToString(ext.Value); got != der {
+		t.Errorf("expected value %s, got %s", der, got)
+	}
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jamescun/yubca/backend"
+)
+
+var (
+	importCertPath string
+	importForce    bool
+)
+
+var importCert = &cobra.Command{
+	Use:   "import-cert",
+	Short: "import certificate signed by a parent certificate authority",
+	Long: `Import the certificate of an intermediate certificate authority, signed by
+its parent from the request written by init --csr-out, into the slot holding its
+private key.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := readConfig()
+		if err != nil {
+			return fmt.Errorf("could not read config: %w", err)
+		}
+
+		cert, err := readCertificate(importCertPath)
+		if err != nil {
+			return fmt.Errorf("could not read certificate: %w", err)
+		}
+
+		if !cert.BasicConstraintsValid || !cert.IsCA {
+			return fmt.Errorf("certificate is not a certificate authority")
+		} else if cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+			return fmt.Errorf("certificate is not permitted to sign certificates")
+		}
+
+		if got, want := cert.Subject.String(), getDN(cfg.Subject).String(); got != want {
+			return fmt.Errorf("certificate subject %q does not match configured subject %q", got, want)
+		}
+
+		ca, err := openBackend(cfg)
+		if err != nil {
+			return fmt.Errorf("could not open backend: %w", err)
+		}
+		defer ca.Close()
+
+		_, err = ca.Certificate(cfg.Slot)
+		if err == nil && !importForce {
+			return fmt.Errorf("a certificate authority is already configured on slot %q, use --force to replace its certificate", cfg.Slot)
+		} else if err != nil && !errors.Is(err, backend.ErrNotFound) {
+			return fmt.Errorf("could not get certificate authority: %w", err)
+		}
+
+		publicKey, err := ca.PublicKey(cfg.Slot)
+		if errors.Is(err, backend.ErrNotFound) {
+			return fmt.Errorf("no private key on slot %q, run `yubca init --csr-out` first", cfg.Slot)
+		} else if err != nil {
+			return fmt.Errorf("could not read the public key on slot %q to check it matches the certificate, a YubiKey requires firmware 4.3 or later and a key generated on it: %w", cfg.Slot, err)
+		}
+
+		if pub, ok := publicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.PublicKey) {
+			return fmt.Errorf("certificate does not match the private key on slot %q", cfg.Slot)
+		}
+
+		err = ca.SetCertificate(cfg.Slot, cert)
+		if err != nil {
+			return fmt.Errorf("could not set certificate on slot %q: %w", cfg.Slot, err)
+		}
+
+		fmt.Println("Done!")
+
+		return nil
+	},
+}
+
+func init() {
+	importCert.Flags().StringVar(&importCertPath, "cert", "cert.pem", "path to certificate signed by the parent certificate authority")
+	importCert.Flags().BoolVar(&importForce, "force", false, "replace an existing certificate on the slot")
+}
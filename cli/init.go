@@ -4,18 +4,32 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"os"
 	"time"
 
-	"github.com/go-piv/piv-go/piv"
 	"github.com/spf13/cobra"
 
+	"github.com/jamescun/yubca/backend"
 	"github.com/jamescun/yubca/config"
 )
 
+var (
+	csrOutPath string
+	initForce  bool
+)
+
 var initCA = &cobra.Command{
 	Use:   "init",
 	Short: "initialize certificate authority",
+	Long: `Initialize a certificate authority, generating its private key and a
+self-signed root certificate.
+
+With --csr-out, a certificate signing request is written instead, to be signed
+by a parent certificate authority as an intermediate. The certificate it
+returns is then loaded with import-cert.`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg, err := readConfig()
@@ -23,12 +37,7 @@ var initCA = &cobra.Command{
 			return fmt.Errorf("could not read config: %w", err)
 		}
 
-		slot, ok := getSlot(cfg.Slot)
-		if !ok {
-			return fmt.Errorf("unknown slot type %q", cfg.Slot)
-		}
-
-		algo, ok := getAlgorithm(cfg.Algorithm)
+		algo, ok := backend.ParseAlgorithm(cfg.Algorithm)
 		if !ok {
 			return fmt.Errorf("unknown algorithm %q", cfg.Algorithm)
 		}
@@ -38,43 +47,61 @@ var initCA = &cobra.Command{
 			return fmt.Errorf("invalid validity duration: %w", err)
 		}
 
-		mgmt, err := readManagementKey()
+		ca, err := openBackend(cfg)
 		if err != nil {
-			return fmt.Errorf("could not read management key: %w", err)
+			return fmt.Errorf("could not open backend: %w", err)
 		}
+		defer ca.Close()
 
 		// check there isn't an existing certificate authority on the slot.
-		_, err = key.Certificate(slot)
+		_, err = ca.Certificate(cfg.Slot)
 		if err == nil {
 			return fmt.Errorf("a certificate authority is already configured on slot %q", cfg.Slot)
+		} else if !errors.Is(err, backend.ErrNotFound) {
+			return fmt.Errorf("could not get certificate authority: %w", err)
+		}
+
+		// a private key without a certificate is most likely an intermediate
+		// waiting for its certificate from import-cert, which would be lost. a
+		// key that cannot be read, such as on a YubiKey that does not support
+		// attestation, is not treated as awaiting a certificate.
+		if !initForce {
+			if _, err := ca.PublicKey(cfg.Slot); err == nil {
+				return fmt.Errorf("a private key is awaiting its certificate on slot %q, run `yubca import-cert` or use --force to replace it", cfg.Slot)
+			}
 		}
 
-		publicKey, err := key.GenerateKey(mgmt, slot, piv.Key{
-			Algorithm:   algo,
-			PINPolicy:   piv.PINPolicyAlways,
-			TouchPolicy: piv.TouchPolicyAlways,
-		})
+		publicKey, err := ca.GenerateKey(cfg.Slot, algo)
 		if err != nil {
 			return fmt.Errorf("could not generate public key: %w", err)
 		}
 
-		privateKey, err := key.PrivateKey(slot, publicKey, piv.KeyAuth{
-			PINPrompt: func() (string, error) {
-				pin, err := readPIN()
-				if err != nil {
-					return pin, err
-				}
-
-				// this is a workaround to display this prompt after the PIN prompt.
-				fmt.Println("Please touch your YubiKey...")
-
-				return pin, nil
-			},
-		})
+		privateKey, err := ca.PrivateKey(cfg.Slot, publicKey)
 		if err != nil {
 			return fmt.Errorf("could not get private key signer: %w", err)
 		}
 
+		if csrOutPath != "" {
+			csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+				Subject: getDN(cfg.Subject),
+			}, privateKey)
+			if err != nil {
+				return fmt.Errorf("could not sign certificate request: %w", err)
+			}
+
+			err = os.WriteFile(csrOutPath, pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE REQUEST",
+				Bytes: csrBytes,
+			}), 0o644)
+			if err != nil {
+				return fmt.Errorf("could not write certificate request: %w", err)
+			}
+
+			fmt.Printf("Wrote certificate request to %s, once it has been signed run `yubca import-cert --cert PATH`.\n", csrOutPath)
+
+			return nil
+		}
+
 		serialNumber, err := randomSerial()
 		if err != nil {
 			return fmt.Errorf("could not generate random serial: %w", err)
@@ -93,6 +120,31 @@ var initCA = &cobra.Command{
 			CRLDistributionPoints: cfg.CRL,
 		}
 
+		// a self-signed root identifies itself as its own authority, which
+		// some clients require to build chains.
+		cert.SubjectKeyId, err = subjectKeyID(publicKey, cfg.SubjectKeyIDMethod)
+		if err != nil {
+			return fmt.Errorf("could not compute subject key identifier: %w", err)
+		}
+
+		cert.AuthorityKeyId = cert.SubjectKeyId
+
+		if cfg.MaxPathLen != nil {
+			cert.MaxPathLen = *cfg.MaxPathLen
+			cert.MaxPathLenZero = cert.MaxPathLen == 0
+		}
+
+		setNameConstraints(cert, cfg.NameConstraints)
+
+		if len(cfg.Policies) > 0 {
+			ext, err := certificatePoliciesExtension(cfg.Policies)
+			if err != nil {
+				return fmt.Errorf("could not encode certificate policies: %w", err)
+			}
+
+			cert.ExtraExtensions = append(cert.ExtraExtensions, ext)
+		}
+
 		certBytes, err := x509.CreateCertificate(rand.Reader, cert, cert, publicKey, privateKey)
 		if err != nil {
 			return fmt.Errorf("could not sign certificate: %w", err)
@@ -103,7 +155,7 @@ var initCA = &cobra.Command{
 			return fmt.Errorf("could not parse signed certificate: %w", err)
 		}
 
-		err = key.SetCertificate(mgmt, slot, signedCert)
+		err = ca.SetCertificate(cfg.Slot, signedCert)
 		if err != nil {
 			return fmt.Errorf("could not set certificate on slot %q: %w", cfg.Slot, err)
 		}
@@ -114,26 +166,9 @@ var initCA = &cobra.Command{
 	},
 }
 
-func getAlgorithm(algo string) (piv.Algorithm, bool) {
-	switch algo {
-	case "ec256", "EC256":
-		return piv.AlgorithmEC256, true
-
-	case "ec384", "EC384":
-		return piv.AlgorithmEC384, true
-
-	case "ed25519", "ED25519":
-		return piv.AlgorithmEd25519, true
-
-	case "rsa1024", "RSA1024":
-		return piv.AlgorithmRSA1024, true
-
-	case "rsa2048", "RSA2048":
-		return piv.AlgorithmRSA2048, true
-
-	default:
-		return 0, false
-	}
+func init() {
+	initCA.Flags().BoolVar(&initForce, "force", false, "replace a private key awaiting its certificate")
+	initCA.Flags().StringVar(&csrOutPath, "csr-out", "", "write a certificate signing request for an intermediate to this path instead of self-signing")
 }
 
 func getDN(dn *config.DN) pkix.Name {
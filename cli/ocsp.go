@@ -0,0 +1,395 @@
+package cli
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/youmark/pkcs8"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/jamescun/yubca/backend"
+	"github.com/jamescun/yubca/config"
+	"github.com/jamescun/yubca/db"
+)
+
+// defaultOCSPValidity is used when the configuration of a Certificate
+// Authority does not specify ocspValidity.
+const defaultOCSPValidity = 24 * time.Hour
+
+// maxOCSPRequestSize limits the size of the body of an OCSP request read by
+// the responder.
+const maxOCSPRequestSize = 10 * 1024
+
+// ocspReadTimeout and ocspWriteTimeout limit how long the responder waits on a
+// client, so slow clients cannot exhaust its connections.
+const (
+	ocspReadTimeout  = 10 * time.Second
+	ocspWriteTimeout = 10 * time.Second
+)
+
+var (
+	ocspListen        string
+	ocspResponderCert string
+	ocspResponderKey  string
+)
+
+var ocspCmd = &cobra.Command{
+	Use:   "ocsp",
+	Short: "answer certificate status requests with ocsp",
+}
+
+var serveOCSP = &cobra.Command{
+	Use:   "serve",
+	Short: "serve ocsp responses over http signed by a delegated responder",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := readConfig()
+		if err != nil {
+			return fmt.Errorf("could not read config: %w", err)
+		}
+
+		if issued == nil {
+			return fmt.Errorf("an issuance database is required, use --db")
+		}
+
+		validity, err := ocspValidity(cfg)
+		if err != nil {
+			return err
+		}
+
+		caCert, err := readCACertificate(cfg)
+		if err != nil {
+			return err
+		}
+
+		responderCert, err := readCertificate(ocspResponderCert)
+		if err != nil {
+			return fmt.Errorf("could not read responder certificate: %w", err)
+		}
+
+		err = checkResponderCertificate(responderCert, caCert)
+		if err != nil {
+			return fmt.Errorf("invalid responder certificate: %w", err)
+		}
+
+		responderKey, err := readPrivateKey(ocspResponderKey)
+		if err != nil {
+			return fmt.Errorf("could not read responder private key: %w", err)
+		}
+
+		if pub, ok := responderKey.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(responderCert.PublicKey) {
+			return fmt.Errorf("responder private key does not match responder certificate")
+		}
+
+		responder := &ocspResponder{
+			db:       issued,
+			issuer:   caCert,
+			cert:     responderCert,
+			key:      responderKey,
+			validity: validity,
+		}
+
+		fmt.Printf("Serving OCSP for %q on %s...\n", caCert.Subject.CommonName, ocspListen)
+
+		server := &http.Server{
+			Addr:              ocspListen,
+			Handler:           responder,
+			ReadHeaderTimeout: ocspReadTimeout,
+			ReadTimeout:       ocspReadTimeout,
+			WriteTimeout:      ocspWriteTimeout,
+		}
+
+		return server.ListenAndServe()
+	},
+}
+
+func init() {
+	serveOCSP.Flags().StringVar(&ocspListen, "listen", ":8080", "address to listen for http requests on")
+	serveOCSP.Flags().StringVar(&ocspResponderCert, "responder-cert", "responder.crt", "path to certificate of the delegated ocsp responder")
+	serveOCSP.Flags().StringVar(&ocspResponderKey, "responder-key", "responder.key", "path to private key of the delegated ocsp responder")
+
+	ocspCmd.AddCommand(serveOCSP)
+	ocspCmd.AddCommand(presignOCSP)
+}
+
+// ocspValidity returns the duration OCSP responses are valid for from the
+// configuration of a Certificate Authority.
+func ocspValidity(cfg *config.CA) (time.Duration, error) {
+	if cfg.OCSPValidity == "" {
+		return defaultOCSPValidity, nil
+	}
+
+	validity, err := time.ParseDuration(cfg.OCSPValidity)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ocsp validity duration: %w", err)
+	}
+
+	return validity, nil
+}
+
+// readCACertificate returns the certificate of the Certificate Authority,
+// closing the backend immediately as the private key is not required.
+func readCACertificate(cfg *config.CA) (*x509.Certificate, error) {
+	ca, err := openBackend(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not open backend: %w", err)
+	}
+	defer ca.Close()
+
+	caCert, err := ca.Certificate(cfg.Slot)
+	if errors.Is(err, backend.ErrNotFound) {
+		return nil, fmt.Errorf("no certificate authority configured on slot %q", cfg.Slot)
+	} else if err != nil {
+		return nil, fmt.Errorf("could not get certificate authority: %w", err)
+	}
+
+	return caCert, nil
+}
+
+// checkResponderCertificate verifies cert was signed by issuer and has been
+// delegated the authority to sign OCSP responses on its behalf.
+func checkResponderCertificate(cert, issuer *x509.Certificate) error {
+	err := cert.CheckSignatureFrom(issuer)
+	if err != nil {
+		return fmt.Errorf("not signed by certificate authority: %w", err)
+	}
+
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("not valid between %s and %s", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+	}
+
+	for _, eku := range cert.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageOCSPSigning {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("missing OCSPSigning extended key usage, sign it with --profile ocsp")
+}
+
+// readPrivateKey reads a PEM encoded private key from path, prompting for a
+// passphrase if it is encrypted.
+func readPrivateKey(path string) (crypto.Signer, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, fmt.Errorf("could not decode PEM block")
+	}
+
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	case "ENCRYPTED PRIVATE KEY":
+		passphrase, perr := readPassphrase()
+		if perr != nil {
+			return nil, fmt.Errorf("could not read passphrase: %w", perr)
+		}
+
+		key, err = pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(passphrase))
+
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+
+	default:
+		return nil, fmt.Errorf("expected PRIVATE KEY, got %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return signer, nil
+}
+
+// ocspResponder answers OCSP requests defined by RFC 6960 for the
+// certificates recorded in an issuance database.
+type ocspResponder struct {
+	db       db.DB
+	issuer   *x509.Certificate
+	cert     *x509.Certificate
+	key      crypto.Signer
+	validity time.Duration
+}
+
+func (o *ocspResponder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		reqBytes []byte
+		err      error
+	)
+
+	// RFC 6960 Appendix A.1
+	switch r.Method {
+	case http.MethodGet:
+		// the request is the last segment of the path, so the responder may
+		// be served under a prefix, and is URL-encoded as base64 may contain
+		// slashes.
+		path := r.URL.EscapedPath()
+
+		var segment string
+		segment, err = url.PathUnescape(path[strings.LastIndex(path, "/")+1:])
+		if err == nil {
+			reqBytes, err = base64.StdEncoding.DecodeString(segment)
+		}
+
+	case http.MethodPost:
+		reqBytes, err = io.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse, 0)
+		return
+	}
+
+	req, err := ocsp.ParseRequest(reqBytes)
+	if err != nil {
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse, 0)
+		return
+	}
+
+	if !o.issuedBy(req) {
+		writeOCSPResponse(w, ocsp.UnauthorizedErrorResponse, 0)
+		return
+	}
+
+	res, err := o.respond(r.Context(), req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: could not respond to ocsp request for %s: %s\n", db.SerialString(req.SerialNumber), err)
+		writeOCSPResponse(w, ocsp.InternalErrorErrorResponse, 0)
+		return
+	}
+
+	maxAge := 0
+	if r.Method == http.MethodGet {
+		maxAge = int(o.validity / time.Second)
+	}
+
+	writeOCSPResponse(w, res, maxAge)
+}
+
+// issuedBy returns true if req identifies the issuer served by this responder.
+func (o *ocspResponder) issuedBy(req *ocsp.Request) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+
+	nameHash, keyHash, err := issuerHashes(o.issuer, req.HashAlgorithm)
+	if err != nil {
+		return false
+	}
+
+	return string(nameHash) == string(req.IssuerNameHash) && string(keyHash) == string(req.IssuerKeyHash)
+}
+
+// respond creates a signed OCSP response for the certificate identified by
+// req from its record in the issuance database, or unknown if it is not
+// recorded.
+func (o *ocspResponder) respond(ctx context.Context, req *ocsp.Request) ([]byte, error) {
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		IssuerHash:   req.HashAlgorithm,
+	}
+
+	record, err := o.db.Certificate(ctx, req.SerialNumber)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, fmt.Errorf("could not read issuance database: %w", err)
+	}
+
+	return createOCSPResponse(o.issuer, o.cert, o.key, template, record, time.Now(), o.validity)
+}
+
+// createOCSPResponse signs an OCSP response with key for the certificate
+// described by record, valid from now until validity has elapsed. A nil
+// record, or one whose serial is pending or abandoned, is reported with the
+// status in template.
+func createOCSPResponse(issuer, cert *x509.Certificate, key crypto.Signer, template ocsp.Response, record *db.Record, now time.Time, validity time.Duration) ([]byte, error) {
+	if record != nil && record.Reservation == nil {
+		template.Status = ocsp.Good
+
+		if record.Revocation != nil {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = record.Revocation.Time
+			template.RevocationReason = record.Revocation.Reason
+		}
+	}
+
+	template.ThisUpdate = now.UTC().Truncate(time.Minute)
+	template.NextUpdate = template.ThisUpdate.Add(validity)
+	template.Certificate = cert
+
+	res, err := ocsp.CreateResponse(issuer, cert, template, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign ocsp response: %w", err)
+	}
+
+	return res, nil
+}
+
+func writeOCSPResponse(w http.ResponseWriter, res []byte, maxAge int) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Header().Set("Content-Length", strconv.Itoa(len(res)))
+
+	if maxAge > 0 {
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(maxAge)+", public, no-transform, must-revalidate")
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// issuerHashes returns the hashes of the subject and public key of issuer
+// used to identify it in an OCSP request, defined by RFC 6960 Section 4.1.1.
+func issuerHashes(issuer *x509.Certificate, hash crypto.Hash) (nameHash, keyHash []byte, err error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+
+	_, err = asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse issuer public key: %w", err)
+	}
+
+	h := hash.New()
+	h.Write(issuer.RawSubject)
+	nameHash = h.Sum(nil)
+
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash = h.Sum(nil)
+
+	return nameHash, keyHash, nil
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/jamescun/yubca/db"
+)
+
+func TestOCSPResponder(t *testing.T) {
+	ca := newSoftwareCA(t, "")
+
+	responderKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responderCert := ca.signKey(responderKey, "ocsp")
+
+	good := ca.sign("server").SerialNumber
+
+	revoked := ca.sign("server").SerialNumber
+	ca.run("revoke", "--serial", db.SerialString(revoked), "--reason", "keyCompromise")
+
+	issuance, err := db.NewJSON(ca.path("issued.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer issuance.Close()
+
+	// a reserved serial was never signed, so has no status to report.
+	reserved := big.NewInt(0x7e57)
+	err = issuance.Reserve(context.Background(), reserved, &db.Issuance{Profile: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := newSoftwareCA(t, "")
+
+	responder := &ocspResponder{
+		db:       issuance,
+		issuer:   ca.cert,
+		cert:     responderCert,
+		key:      responderKey,
+		validity: time.Hour,
+	}
+
+	request := func(serial *big.Int, issuer *softwareCA) []byte {
+		t.Helper()
+
+		nameHash, keyHash, err := issuerHashes(issuer.cert, crypto.SHA1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := &ocsp.Request{HashAlgorithm: crypto.SHA1, IssuerNameHash: nameHash, IssuerKeyHash: keyHash, SerialNumber: serial}
+
+		der, err := req.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return der
+	}
+
+	get := func(path string, der []byte) *http.Request {
+		return httptest.NewRequest(http.MethodGet, path+url.PathEscape(base64.StdEncoding.EncodeToString(der)), nil)
+	}
+
+	post := func(der []byte) *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(der))
+	}
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		err    ocsp.ResponseStatus
+		cached bool
+	}{
+		{"GetGood", get("/", request(good, ca)), ocsp.Good, ocsp.Success, true},
+		{"GetPrefixed", get("/ocsp/", request(good, ca)), ocsp.Good, ocsp.Success, true},
+		{"PostGood", post(request(good, ca)), ocsp.Good, ocsp.Success, false},
+		{"PostRevoked", post(request(revoked, ca)), ocsp.Revoked, ocsp.Success, false},
+		{"GetUnknown", get("/", request(big.NewInt(0xdead), ca)), ocsp.Unknown, ocsp.Success, true},
+		{"PostReserved", post(request(reserved, ca)), ocsp.Unknown, ocsp.Success, false},
+		{"OtherIssuer", post(request(good, other)), 0, ocsp.Unauthorized, false},
+		{"Malformed", post([]byte("yubca")), 0, ocsp.Malformed, false},
+		{"MalformedGet", httptest.NewRequest(http.MethodGet, "/yubca!", nil), 0, ocsp.Malformed, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			responder.ServeHTTP(w, test.req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected http status 200, got %d", w.Code)
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != "application/ocsp-response" {
+				t.Errorf("expected content type application/ocsp-response, got %q", ct)
+			}
+
+			if cached := w.Header().Get("Cache-Control") != ""; cached != test.cached {
+				t.Errorf("expected cached %v, got %q", test.cached, w.Header().Get("Cache-Control"))
+			}
+
+			res, err := ocsp.ParseResponse(w.Body.Bytes(), ca.cert)
+			if test.err != ocsp.Success {
+				var re ocsp.ResponseError
+				if !errors.As(err, &re) || re.Status != test.err {
+					t.Fatalf("expected response status %s, got %v", test.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("could not parse response: %s", err)
+			}
+
+			if res.Status != test.status {
+				t.Errorf("expected status %d, got %d", test.status, res.Status)
+			}
+
+			if test.status == ocsp.Revoked && res.RevocationReason != ocsp.KeyCompromise {
+				t.Errorf("expected reason keyCompromise, got %d", res.RevocationReason)
+			}
+
+			if !res.NextUpdate.Equal(res.ThisUpdate.Add(time.Hour)) {
+				t.Errorf("expected response valid for 1h, got %s to %s", res.ThisUpdate, res.NextUpdate)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	responder.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", nil))
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected http status 405, got %d", w.Code)
+	}
+}
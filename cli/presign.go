@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/jamescun/yubca/backend"
+	"github.com/jamescun/yubca/db"
+)
+
+var presignOutputDir string
+
+var presignOCSP = &cobra.Command{
+	Use:   "presign",
+	Short: "pre-sign ocsp responses for every certificate in the issuance database",
+	Long: `Pre-sign OCSP responses for every certificate in the issuance database,
+writing them to a directory as <serial>.der to be served by a static web server.
+
+Responses are signed by an ephemeral delegated responder, whose certificate is
+signed by the certificate authority, so only one touch is required however many
+certificates have been issued.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		cfg, err := readConfig()
+		if err != nil {
+			return fmt.Errorf("could not read config: %w", err)
+		}
+
+		if issued == nil {
+			return fmt.Errorf("an issuance database is required, use --db")
+		}
+
+		validity, err := ocspValidity(cfg)
+		if err != nil {
+			return err
+		}
+
+		records, err := issued.Certificates(ctx)
+		if err != nil {
+			return fmt.Errorf("could not read issuance database: %w", err)
+		}
+
+		err = os.MkdirAll(presignOutputDir, 0o755)
+		if err != nil {
+			return fmt.Errorf("could not create output directory: %w", err)
+		}
+
+		ca, err := openBackend(cfg)
+		if err != nil {
+			return fmt.Errorf("could not open backend: %w", err)
+		}
+		defer ca.Close()
+
+		caCert, err := ca.Certificate(cfg.Slot)
+		if errors.Is(err, backend.ErrNotFound) {
+			return fmt.Errorf("no certificate authority configured on slot %q", cfg.Slot)
+		} else if err != nil {
+			return fmt.Errorf("could not get certificate authority: %w", err)
+		}
+
+		caPrivateKey, err := ca.PrivateKey(cfg.Slot, caCert.PublicKey)
+		if err != nil {
+			return fmt.Errorf("could not get private key signer: %w", err)
+		}
+
+		// the responder key only exists in memory for the duration of this
+		// command, and its certificate expires with the responses it signs.
+		responderKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("could not generate responder key: %w", err)
+		}
+
+		issuance := &db.Issuance{Profile: "ocsp", Operator: currentOperator()}
+
+		serialNumber, err := reserveSerial(ctx, cfg, issuance)
+		if err != nil {
+			return err
+		}
+
+		var committed bool
+		defer abandonSerial(ctx, serialNumber, &committed)
+
+		now := time.Now().UTC().Truncate(time.Minute)
+
+		responderCert := &x509.Certificate{
+			Version:               1,
+			SerialNumber:          serialNumber,
+			Issuer:                caCert.Subject,
+			Subject:               pkix.Name{CommonName: caCert.Subject.CommonName + " OCSP Responder"},
+			NotBefore:             now,
+			NotAfter:              now.Add(validity),
+			KeyUsage:              x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+			BasicConstraintsValid: true,
+			ExtraExtensions:       []pkix.Extension{ocspNoCheckExtension()},
+			AuthorityKeyId:        caCert.SubjectKeyId,
+		}
+
+		responderCert.SubjectKeyId, err = subjectKeyID(responderKey.Public(), cfg.SubjectKeyIDMethod)
+		if err != nil {
+			return fmt.Errorf("could not compute subject key identifier: %w", err)
+		}
+
+		responderBytes, err := x509.CreateCertificate(rand.Reader, responderCert, caCert, responderKey.Public(), caPrivateKey)
+		if err != nil {
+			return fmt.Errorf("could not sign responder certificate: %w", err)
+		}
+
+		responderCert, err = x509.ParseCertificate(responderBytes)
+		if err != nil {
+			return fmt.Errorf("could not parse responder certificate: %w", err)
+		}
+
+		err = issued.AppendCertificate(ctx, responderCert, issuance)
+		if err != nil {
+			return fmt.Errorf("could not append responder certificate to issuance database: %w", err)
+		}
+
+		committed = true
+
+		var n int
+		for _, record := range records {
+			// serials that were never signed have no certificate to respond
+			// for, and are reported as unknown by ocsp serve.
+			if record.Reservation != nil {
+				continue
+			}
+
+			serial, ok := new(big.Int).SetString(record.Serial, 16)
+			if !ok {
+				return fmt.Errorf("invalid serial %q in issuance database", record.Serial)
+			}
+
+			res, err := createOCSPResponse(caCert, responderCert, responderKey, ocsp.Response{SerialNumber: serial}, record, now, validity)
+			if err != nil {
+				return fmt.Errorf("could not create ocsp response for %s: %w", record.Serial, err)
+			}
+
+			err = writeFileAtomic(filepath.Join(presignOutputDir, record.Serial+".der"), res)
+			if err != nil {
+				return fmt.Errorf("could not write ocsp response for %s: %w", record.Serial, err)
+			}
+
+			n++
+		}
+
+		fmt.Printf("Pre-signed %d OCSP responses valid until %s.\n", n, now.Add(validity).Format(time.RFC3339))
+
+		return nil
+	},
+}
+
+func init() {
+	presignOCSP.Flags().StringVar(&presignOutputDir, "output", "ocsp", "directory to write ocsp responses to")
+}
+
+// writeFileAtomic writes data to path by way of a temporary file, so a
+// partially written file is never served.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+
+	err := os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
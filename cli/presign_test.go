@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/jamescun/yubca/db"
+)
+
+func TestPresignOCSP(t *testing.T) {
+	ctx := context.Background()
+
+	ca := newSoftwareCA(t, `"ocspValidity": "2h"`)
+
+	good := ca.sign("server").SerialNumber
+
+	revoked := ca.sign("client").SerialNumber
+	ca.run("revoke", "--serial", db.SerialString(revoked), "--reason", "superseded")
+
+	reserved := big.NewInt(0x7e57)
+
+	issuance, err := db.NewJSON(ca.path("issued.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = issuance.Reserve(ctx, reserved, &db.Issuance{Profile: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuance.Close()
+
+	ca.run("ocsp", "presign", "--output", ca.path("ocsp"))
+
+	tests := []struct {
+		name   string
+		serial *big.Int
+		status int
+	}{
+		{"Good", good, ocsp.Good},
+		{"Revoked", revoked, ocsp.Revoked},
+		{"Reserved", reserved, -1},
+	}
+
+	var responder *big.Int
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			der, err := os.ReadFile(ca.path("ocsp", db.SerialString(test.serial)+".der"))
+			if test.status < 0 {
+				if !os.IsNotExist(err) {
+					t.Fatalf("expected no response, got %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := ocsp.ParseResponse(der, ca.cert)
+			if err != nil {
+				t.Fatalf("could not parse response: %s", err)
+			}
+
+			if res.SerialNumber.Cmp(test.serial) != 0 {
+				t.Errorf("expected serial %s, got %s", db.SerialString(test.serial), db.SerialString(res.SerialNumber))
+			}
+
+			if res.Status != test.status {
+				t.Errorf("expected status %d, got %d", test.status, res.Status)
+			}
+
+			// the ephemeral responder is only trusted for as long as the
+			// responses it signed.
+			cert := res.Certificate
+			if cert == nil {
+				t.Fatalf("expected response to include responder certificate")
+			}
+
+			if !cert.NotAfter.Equal(res.NextUpdate) || !res.NextUpdate.Equal(res.ThisUpdate.Add(2*time.Hour)) {
+				t.Errorf("expected responder and response valid for 2h from %s, got %s and %s", res.ThisUpdate, cert.NotAfter, res.NextUpdate)
+			}
+
+			if err := checkResponderCertificate(cert, ca.cert); err != nil {
+				t.Errorf("invalid responder certificate: %s", err)
+			}
+
+			if responder == nil {
+				responder = cert.SerialNumber
+			} else if responder.Cmp(cert.SerialNumber) != 0 {
+				t.Errorf("expected every response signed by one responder")
+			}
+		})
+	}
+
+	entries, err := os.ReadDir(ca.path("ocsp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+
+		t.Errorf("expected 2 responses, got %q", names)
+	}
+
+	if responder == nil {
+		t.Fatal("expected a responder certificate")
+	}
+
+	issuance, err = db.NewJSON(ca.path("issued.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer issuance.Close()
+
+	record, err := issuance.Certificate(ctx, responder)
+	if err != nil {
+		t.Fatalf("could not find responder in issuance database: %s", err)
+	}
+
+	if record.Profile != "ocsp" {
+		t.Errorf("expected responder recorded with profile ocsp, got %q", record.Profile)
+	}
+
+}
@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jamescun/yubca/db"
+)
+
+var (
+	revokeSerial   string
+	revokeCertPath string
+	revokeReason   string
+	revokeTime     string
+	revokeForce    bool
+	revokeRelease  bool
+)
+
+// revocationReasons are the CRLReason codes defined by RFC 5280 Section
+// 5.3.1 that a certificate may be revoked with. removeFromCRL is not a reason
+// for revocation, it is only listed by delta CRLs when a hold is released.
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// CRLReason codes with meaning to yubca beyond recording them.
+const (
+	reasonCertificateHold = 6
+	reasonRemoveFromCRL   = 8
+)
+
+var revoke = &cobra.Command{
+	Use:   "revoke",
+	Short: "revoke a certificate in the issuance database",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		if issued == nil {
+			return fmt.Errorf("an issuance database is required, use --db")
+		}
+
+		serial, err := getRevokeSerial()
+		if err != nil {
+			return err
+		}
+
+		if revokeRelease {
+			return releaseHold(ctx, serial)
+		}
+
+		reason, err := parseRevocationReason(revokeReason)
+		if err != nil {
+			return err
+		}
+
+		revokedAt := time.Now()
+		if revokeTime != "" {
+			revokedAt, err = time.Parse(time.RFC3339, revokeTime)
+			if err != nil {
+				return fmt.Errorf("invalid revocation time: %w", err)
+			}
+		}
+
+		record, err := issued.Certificate(ctx, serial)
+		if errors.Is(err, db.ErrNotFound) {
+			if !revokeForce {
+				return fmt.Errorf("certificate %s not found in issuance database, use --force to revoke anyway", db.SerialString(serial))
+			}
+		} else if err != nil {
+			return fmt.Errorf("could not get certificate from issuance database: %w", err)
+		} else if record.Revocation != nil && record.Revocation.Reason != reasonCertificateHold {
+			return fmt.Errorf("certificate %s was already revoked at %s", record.Serial, record.Revocation.Time.Format(time.RFC3339))
+		}
+
+		err = issued.RevokeCertificate(ctx, serial, &db.Revocation{
+			Time:   revokedAt.UTC(),
+			Reason: reason,
+		})
+		if err != nil {
+			return fmt.Errorf("could not revoke certificate: %w", err)
+		}
+
+		fmt.Printf("Revoked %s\n", db.SerialString(serial))
+
+		return nil
+	},
+}
+
+func init() {
+	revoke.Flags().StringVar(&revokeSerial, "serial", "", "hex-encoded serial number of certificate to revoke")
+	revoke.Flags().StringVar(&revokeCertPath, "cert", "", "path to PEM-encoded certificate to revoke")
+	revoke.Flags().StringVar(&revokeReason, "reason", "unspecified", "RFC 5280 reason for revocation, by name or code")
+	revoke.Flags().StringVar(&revokeTime, "time", "", "RFC 3339 time the certificate was revoked (default now)")
+	revoke.Flags().BoolVar(&revokeForce, "force", false, "revoke certificates not found in the issuance database")
+	revoke.Flags().BoolVar(&revokeRelease, "release", false, "release a certificate from certificateHold rather than revoking it")
+}
+
+// releaseHold releases the certificate with serial from certificateHold, after
+// which delta CRLs list it as removeFromCRL and complete CRLs omit it.
+func releaseHold(ctx context.Context, serial *big.Int) error {
+	record, err := issued.Certificate(ctx, serial)
+	if errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("certificate %s not found in issuance database", db.SerialString(serial))
+	} else if err != nil {
+		return fmt.Errorf("could not get certificate from issuance database: %w", err)
+	} else if record.Revocation == nil || record.Revocation.Reason != reasonCertificateHold {
+		return fmt.Errorf("certificate %s is not on hold", record.Serial)
+	}
+
+	err = issued.RevokeCertificate(ctx, serial, nil)
+	if err != nil {
+		return fmt.Errorf("could not release certificate: %w", err)
+	}
+
+	fmt.Printf("Released %s\n", record.Serial)
+
+	return nil
+}
+
+func getRevokeSerial() (*big.Int, error) {
+	switch {
+	case revokeSerial != "" && revokeCertPath != "":
+		return nil, fmt.Errorf("only one of --serial or --cert may be given")
+
+	case revokeSerial != "":
+		return parseSerial(revokeSerial)
+
+	case revokeCertPath != "":
+		cert, err := readCertificate(revokeCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read certificate: %w", err)
+		}
+
+		return cert.SerialNumber, nil
+
+	default:
+		return nil, fmt.Errorf("one of --serial or --cert is required")
+	}
+}
+
+// parseRevocationReason returns the CRLReason code for reason, which may be
+// its name or code.
+func parseRevocationReason(reason string) (int, error) {
+	if reason == "removeFromCRL" || reason == strconv.Itoa(reasonRemoveFromCRL) {
+		return 0, fmt.Errorf("removeFromCRL is not a reason for revocation, it is only used by delta crls to release a certificateHold")
+	}
+
+	if code, ok := revocationReasons[reason]; ok {
+		return code, nil
+	}
+
+	code, err := strconv.Atoi(reason)
+	if err == nil {
+		for _, c := range revocationReasons {
+			if c == code {
+				return code, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unknown revocation reason %q", reason)
+}
+
+// revocationReasonName returns the name of the CRLReason code, or the code
+// itself if it is unknown.
+func revocationReasonName(code int) string {
+	for name, c := range revocationReasons {
+		if c == code {
+			return name
+		}
+	}
+
+	return strconv.Itoa(code)
+}
+
+// parseSerial parses a hex-encoded serial number, optionally separated by
+// colons.
+func parseSerial(str string) (*big.Int, error) {
+	serial, ok := new(big.Int).SetString(strings.ReplaceAll(str, ":", ""), 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid serial %q, expected hex", str)
+	}
+
+	return serial, nil
+}
@@ -2,14 +2,16 @@ package cli
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
-	"github.com/go-piv/piv-go/piv"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 
+	"github.com/jamescun/yubca/backend"
 	"github.com/jamescun/yubca/config"
 	"github.com/jamescun/yubca/db"
 )
@@ -20,8 +22,6 @@ var (
 	keyID      int
 )
 
-var key *piv.YubiKey
-
 var issued db.DB
 
 var root = &cobra.Command{
@@ -29,44 +29,42 @@ var root = &cobra.Command{
 	Short: "yubca manages a certificate authority on a yubikey",
 
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		cards, err := piv.Cards()
-		if err != nil {
-			return fmt.Errorf("could not list keys: %w", err)
-		}
-
-		if len(cards) < 1 {
-			return fmt.Errorf("no keys found")
-		}
-
-		if keyID >= len(cards) {
-			return fmt.Errorf("key id %d not found", keyID)
-		}
-
-		key, err = piv.Open(cards[keyID])
-		if err != nil {
-			return fmt.Errorf("could not connect to key: %w", err)
-		}
+		var err error
 
 		if dbFile != "" {
-			issued, err = db.NewJSON(dbFile)
+			issued, err = openDB(dbFile)
 			if err != nil {
-				return fmt.Errorf("could not create json issuance database")
+				return fmt.Errorf("could not open issuance database: %w", err)
 			}
 		}
 
 		return nil
 	},
+
+	PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+		if issued != nil {
+			return issued.Close()
+		}
+
+		return nil
+	},
 }
 
 func init() {
 	root.PersistentFlags().StringVar(&configFile, "config", "ca.json", "path to certificate authority json configuration")
-	root.PersistentFlags().StringVar(&dbFile, "db", "", "path to json database of issued certificates")
+	root.PersistentFlags().StringVar(&dbFile, "db", "", "path to database of issued certificates, prefix with sqlite: to use sqlite rather than json")
 	root.PersistentFlags().IntVar(&keyID, "key-id", 0, "id of yubikey to operate certificate authority from")
 
 	root.AddCommand(initCA)
+	root.AddCommand(importCert)
+	root.AddCommand(deleteCA)
 	root.AddCommand(inspectCA)
 	root.AddCommand(export)
 	root.AddCommand(signCSR)
+	root.AddCommand(revoke)
+	root.AddCommand(generateCRL)
+	root.AddCommand(ocspCmd)
+	root.AddCommand(dbCmd)
 }
 
 // SetVersion overwrites the Version on the Root of the CLI with a subcommand
@@ -112,6 +110,64 @@ func readConfig() (*config.CA, error) {
 	return cfg, nil
 }
 
+// openBackend connects to the Backend storing the private key and certificate
+// of the Certificate Authority described by cfg.
+func openBackend(cfg *config.CA) (backend.Backend, error) {
+	auth := &backend.Auth{
+		PIN:           readPIN,
+		ManagementKey: readManagementKey,
+		Passphrase:    readPassphrase,
+	}
+
+	switch cfg.Backend {
+	case "", "yubikey":
+		auth.PIN = func() (string, error) {
+			pin, err := readPIN()
+			if err != nil {
+				return pin, err
+			}
+
+			// this is a workaround to display this prompt after the PIN prompt.
+			fmt.Print("Please touch your YubiKey...\n\n")
+
+			return pin, nil
+		}
+
+		var pinProtected bool
+		if cfg.YubiKey != nil {
+			pinProtected = cfg.YubiKey.PINProtectedManagementKey
+		}
+
+		return backend.NewYubiKey(keyID, pinProtected, auth)
+
+	case "software":
+		return backend.NewSoftware(cfg.Software.Path, auth)
+
+	case "pkcs11":
+		// tokens have no default PIN to fall back to.
+		auth.PIN = func() (string, error) {
+			return readSecret(pinSource, "Token PIN")
+		}
+
+		return backend.NewPKCS11(cfg.PKCS11.Module, cfg.PKCS11.Token, cfg.PKCS11.Key, auth)
+
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+}
+
+// openDB opens the issuance database at path, which is a JSON database unless
+// prefixed with sqlite:.
+func openDB(path string) (db.DB, error) {
+	switch {
+	case strings.HasPrefix(path, "sqlite:"):
+		return db.NewSQLite(strings.TrimPrefix(path, "sqlite:"))
+
+	default:
+		return db.NewJSON(strings.TrimPrefix(path, "json:"))
+	}
+}
+
 func readPassword(prompt string) (string, error) {
 	fmt.Printf("%s: ", prompt)
 	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
@@ -123,47 +179,46 @@ func readPassword(prompt string) (string, error) {
 	return string(bytes.TrimSpace(pass)), nil
 }
 
+// readPIN prompts for the PIN of a YubiKey, an empty PIN indicates the default
+// PIN should be used.
 func readPIN() (string, error) {
-	pin, err := readPassword("PIN (leave blank for default)")
-	if err != nil {
-		return piv.DefaultPIN, err
-	}
-
-	if pin == "" {
-		return piv.DefaultPIN, nil
-	}
+	return readSecret(pinSource, "PIN (leave blank for default)")
+}
 
-	return pin, nil
+// readPassphrase prompts for a passphrase, an empty passphrase indicates the
+// private key is not encrypted.
+func readPassphrase() (string, error) {
+	return readSecret(passphraseSource, "Passphrase (leave blank for none)")
 }
 
-func readManagementKey() ([24]byte, error) {
-	key, err := readPassword("Management Key (leave blank for default)")
+// readManagementKey prompts for a management key, an empty key indicates the
+// default management key of the Backend should be used.
+func readManagementKey() ([]byte, error) {
+	key, err := readSecret(managementKeySource, "Management Key (leave blank for default)")
 	if err != nil {
-		return piv.DefaultManagementKey, err
+		return nil, err
 	}
 
 	if key == "" {
-		return piv.DefaultManagementKey, nil
+		return nil, nil
 	}
 
-	return piv.DefaultManagementKey, nil
+	return parseManagementKey(key)
 }
 
-func getSlot(slotType string) (piv.Slot, bool) {
-	switch slotType {
-	case "9a":
-		return piv.SlotAuthentication, true
-
-	case "9c":
-		return piv.SlotSignature, true
-
-	case "9e":
-		return piv.SlotCardAuthentication, true
+// parseManagementKey decodes a hex-encoded management key, which may be 3DES
+// (48 characters) or AES-128, AES-192 or AES-256 (32, 48 or 64 characters).
+func parseManagementKey(str string) ([]byte, error) {
+	key, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, fmt.Errorf("management key must be hex-encoded: %w", err)
+	}
 
-	case "9d":
-		return piv.SlotKeyManagement, true
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
 
 	default:
-		return piv.Slot{}, false
+		return nil, fmt.Errorf("management key must be 48 hex characters for 3DES, or 32, 48 or 64 hex characters for AES-128, AES-192 or AES-256, got %d characters", len(str))
 	}
 }
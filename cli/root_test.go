@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseManagementKey(t *testing.T) {
+	tests := []struct {
+		name string
+		str  string
+		key  []byte
+		err  string
+	}{
+		{"3DES", "010203040506070801020304050607080102030405060708", []byte{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8}, ""},
+		{"AES128", strings.Repeat("ab", 16), bytes.Repeat([]byte{0xab}, 16), ""},
+		{"AES256", strings.Repeat("AB", 32), bytes.Repeat([]byte{0xab}, 32), ""},
+		{"Empty", "", nil, "got 0 characters"},
+		{"Short", strings.Repeat("00", 8), nil, "got 16 characters"},
+		{"Long", strings.Repeat("00", 33), nil, "got 66 characters"},
+		{"OddLength", strings.Repeat("0", 47), nil, "must be hex-encoded"},
+		{"NotHex", strings.Repeat("zz", 24), nil, "must be hex-encoded"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := parseManagementKey(test.str)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !bytes.Equal(key, test.key) {
+				t.Errorf("expected key %x, got %x", test.key, key)
+			}
+		})
+	}
+}
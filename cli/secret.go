@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	pinSource           string
+	managementKeySource string
+	passphraseSource    string
+)
+
+var (
+	// secrets caches each secret read by readSecret, keyed by its source and
+	// prompt, as a source such as a file descriptor can only be read once but
+	// the secret may be needed again, such as the passphrase of a private key
+	// being both encrypted and decrypted by init.
+	secrets = map[string]string{}
+
+	// fdFiles holds the inherited file descriptors opened by readSecret, so
+	// they are not closed when garbage collected.
+	fdFiles = map[int]*os.File{}
+)
+
+func init() {
+	root.PersistentFlags().StringVar(&pinSource, "pin-from", "", "read PIN from env:NAME, file:PATH, fd:N or cmd:COMMAND instead of prompting")
+	root.PersistentFlags().StringVar(&managementKeySource, "management-key-from", "", "read management key from env:NAME, file:PATH, fd:N or cmd:COMMAND instead of prompting")
+	root.PersistentFlags().StringVar(&passphraseSource, "passphrase-from", "", "read private key passphrase from env:NAME, file:PATH, fd:N or cmd:COMMAND instead of prompting")
+}
+
+// readSecret reads a secret from source, or prompts for it on the terminal if
+// source is empty. Sources take the form:
+//
+//	env:NAME     the value of the environment variable NAME
+//	file:PATH    the first line of the file at PATH
+//	fd:N         the first line read from the open file descriptor N, which
+//	             is left open
+//	cmd:COMMAND  the first line written to stdout by COMMAND, run by the
+//	             shell, which is given the prompt in the YUBCA_PROMPT
+//	             environment variable
+//
+// Each secret is only read once, and reused if required again.
+func readSecret(source, prompt string) (string, error) {
+	key := source + "\x00" + prompt
+	if secret, ok := secrets[key]; ok {
+		return secret, nil
+	}
+
+	secret, err := readSecretSource(source, prompt)
+	if err != nil {
+		return "", err
+	}
+
+	secrets[key] = secret
+
+	return secret, nil
+}
+
+func readSecretSource(source, prompt string) (string, error) {
+	if source == "" {
+		return readPassword(prompt)
+	}
+
+	kind, value, ok := strings.Cut(source, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid secret source %q", source)
+	}
+
+	switch kind {
+	case "env":
+		secret, ok := os.LookupEnv(value)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q not set", value)
+		}
+
+		return strings.TrimSpace(secret), nil
+
+	case "file":
+		file, err := os.Open(value)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+
+		return readFirstLine(file)
+
+	case "fd":
+		fd, err := strconv.Atoi(value)
+		if err != nil || fd < 0 {
+			return "", fmt.Errorf("invalid file descriptor %q", value)
+		}
+
+		file, ok := fdFiles[fd]
+		if !ok {
+			file = os.NewFile(uintptr(fd), "fd"+value)
+			if file == nil {
+				return "", fmt.Errorf("invalid file descriptor %q", value)
+			}
+
+			fdFiles[fd] = file
+		}
+
+		// the descriptor may hold further secrets, so it is read a byte at a
+		// time to avoid consuming past the first line.
+		return readFirstLine(byteReader{file})
+
+	case "cmd":
+		if strings.TrimSpace(value) == "" {
+			return "", fmt.Errorf("secret command is required")
+		}
+
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", value)
+		} else {
+			cmd = exec.Command("/bin/sh", "-c", value)
+		}
+
+		cmd.Env = append(os.Environ(), "YUBCA_PROMPT="+prompt)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", value, err)
+		}
+
+		return readFirstLine(bytes.NewReader(out))
+
+	default:
+		return "", fmt.Errorf("unknown secret source %q", kind)
+	}
+}
+
+func readFirstLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+// byteReader reads from r at most one byte at a time.
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+
+	return b.r.Read(p)
+}
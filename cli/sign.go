@@ -1,27 +1,37 @@
 package cli
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"os"
+	"os/user"
 	"time"
 
-	"github.com/go-piv/piv-go/piv"
 	"github.com/spf13/cobra"
+
+	"github.com/jamescun/yubca/backend"
+	"github.com/jamescun/yubca/config"
+	"github.com/jamescun/yubca/db"
 )
 
 var (
 	csrPath     string
-	isCA        bool
-	serverAuth  bool
-	clientAuth  bool
+	profileName string
 	validityStr string
 	outputPath  string
+	requester   string
 )
 
 var signCSR = &cobra.Command{
@@ -36,14 +46,36 @@ var signCSR = &cobra.Command{
 			return fmt.Errorf("could not read config: %w", err)
 		}
 
-		validity, err := time.ParseDuration(validityStr)
+		err = resolveLegacyProfile(cmd)
 		if err != nil {
-			return fmt.Errorf("invalid validity duration: %w", err)
+			return err
+		}
+
+		if profileName == "" {
+			return fmt.Errorf("a profile is required, such as --profile server")
 		}
 
-		slot, ok := getSlot(cfg.Slot)
+		profile, ok := cfg.Profile(profileName)
 		if !ok {
-			return fmt.Errorf("unknown slot type %q", cfg.Slot)
+			return fmt.Errorf("unknown profile %q", profileName)
+		}
+
+		validity, err := time.ParseDuration(profile.Validity)
+		if err != nil {
+			return fmt.Errorf("invalid profile validity duration: %w", err)
+		}
+
+		if validityStr != "" {
+			requested, err := time.ParseDuration(validityStr)
+			if err != nil {
+				return fmt.Errorf("invalid validity duration: %w", err)
+			}
+
+			if requested > validity {
+				return fmt.Errorf("validity %s exceeds maximum of %s for profile %q", requested, validity, profileName)
+			}
+
+			validity = requested
 		}
 
 		csr, err := readCSR(csrPath)
@@ -51,35 +83,80 @@ var signCSR = &cobra.Command{
 			return fmt.Errorf("could not read certificate request: %w", err)
 		}
 
-		caCert, err := key.Certificate(slot)
-		if errors.Is(err, piv.ErrNotFound) {
+		err = checkCSR(csr)
+		if err != nil {
+			return fmt.Errorf("refusing to sign certificate request: %w", err)
+		}
+
+		err = checkSANs(csr, profileName, profile)
+		if err != nil {
+			return fmt.Errorf("refusing to sign certificate request: %w", err)
+		}
+
+		ca, err := openBackend(cfg)
+		if err != nil {
+			return fmt.Errorf("could not open backend: %w", err)
+		}
+		defer ca.Close()
+
+		caCert, err := ca.Certificate(cfg.Slot)
+		if errors.Is(err, backend.ErrNotFound) {
 			return fmt.Errorf("no certificate authority configured on slot %q", cfg.Slot)
 		} else if err != nil {
 			return fmt.Errorf("could not get certificate authority: %w", err)
 		}
 
-		caPrivateKey, err := key.PrivateKey(slot, caCert.PublicKey, piv.KeyAuth{
-			PINPrompt: func() (string, error) {
-				pin, err := readPIN()
-				if err != nil {
-					return pin, err
-				}
+		err = checkPathLen(caCert, profileName, profile)
+		if err != nil {
+			return err
+		}
+
+		err = checkNameConstraints(caCert, csr)
+		if err != nil {
+			return err
+		}
 
-				// this is a workaround to display this prompt after the PIN prompt.
-				fmt.Print("Please touch your YubiKey...\n\n")
+		if len(caCert.SubjectKeyId) == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: certificate authority has no subject key identifier, the certificate will have no authority key identifier.\n")
+		}
 
-				return pin, nil
-			},
-		})
+		caPrivateKey, err := ca.PrivateKey(cfg.Slot, caCert.PublicKey)
 		if err != nil {
 			return fmt.Errorf("could not get private key signer: %w", err)
 		}
 
-		serialNumber, err := randomSerial()
+		// the output is opened before a serial is reserved, so the signed
+		// certificate can always be written once it has been recorded.
+		out := os.Stdout
+		if outputPath != "" {
+			file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+			if err != nil {
+				return fmt.Errorf("could not create certificate path: %w", err)
+			}
+			defer file.Close()
+
+			out = file
+		}
+
+		issuance := &db.Issuance{
+			Profile:   profileName,
+			Requester: requester,
+			Operator:  currentOperator(),
+			CSR:       csr,
+		}
+
+		// the serial is reserved before signing, and the certificate recorded
+		// before it is written, so no certificate is ever released without a
+		// record of it. a reservation left pending by an interrupted signing
+		// is reconciled by `yubca db verify`.
+		serialNumber, err := reserveSerial(ctx, cfg, issuance)
 		if err != nil {
-			return fmt.Errorf("could not generate random serial: %w", err)
+			return err
 		}
 
+		var committed bool
+		defer abandonSerial(ctx, serialNumber, &committed)
+
 		cert := &x509.Certificate{
 			Version:               1,
 			SerialNumber:          serialNumber,
@@ -87,25 +164,90 @@ var signCSR = &cobra.Command{
 			Subject:               csr.Subject,
 			NotBefore:             time.Now(),
 			NotAfter:              time.Now().Add(validity),
-			KeyUsage:              x509.KeyUsageDigitalSignature,
+			KeyUsage:              profile.KeyUsages(),
 			BasicConstraintsValid: true,
-			IsCA:                  isCA,
+			IsCA:                  profile.CA,
 			DNSNames:              csr.DNSNames,
 			IPAddresses:           csr.IPAddresses,
 			URIs:                  csr.URIs,
 			EmailAddresses:        csr.EmailAddresses,
+			OCSPServer:            cfg.OCSP,
+			IssuingCertificateURL: cfg.CAIssuers,
+			AuthorityKeyId:        caCert.SubjectKeyId,
+		}
+
+		cert.SubjectKeyId, err = subjectKeyID(csr.PublicKey, cfg.SubjectKeyIDMethod)
+		if err != nil {
+			return fmt.Errorf("could not compute subject key identifier: %w", err)
 		}
 
-		if isCA {
+		cert.ExtKeyUsage, cert.UnknownExtKeyUsage = profile.ExtKeyUsages()
+
+		if profile.CA {
 			cert.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+			if profile.MaxPathLen != nil {
+				cert.MaxPathLen = *profile.MaxPathLen
+				cert.MaxPathLenZero = cert.MaxPathLen == 0
+			}
+
+			setNameConstraints(cert, profile.NameConstraints)
 		}
 
-		if serverAuth {
-			cert.ExtKeyUsage = append(cert.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+		var deltaCRL []string
+		cert.CRLDistributionPoints, deltaCRL = crlDistributionPoints(cfg, serialNumber, profileName, profile)
+
+		if len(cert.CRLDistributionPoints) == 0 && (len(cfg.CRL) > 0 || len(cfg.CRLPartitions) > 0) {
+			fmt.Fprintf(os.Stderr, "Warning: certificate authority publishes certificate revocation lists, but the certificate will have no crl distribution point.\n")
 		}
 
-		if clientAuth {
-			cert.ExtKeyUsage = append(cert.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		if len(deltaCRL) > 0 {
+			ext, err := freshestCRLExtension(deltaCRL)
+			if err != nil {
+				return fmt.Errorf("could not encode freshest crl: %w", err)
+			}
+
+			cert.ExtraExtensions = append(cert.ExtraExtensions, ext)
+		}
+
+		policies := profile.Policies
+		if policies == nil {
+			policies = cfg.Policies
+		}
+
+		if len(policies) > 0 {
+			ext, err := certificatePoliciesExtension(policies)
+			if err != nil {
+				return fmt.Errorf("could not encode certificate policies: %w", err)
+			}
+
+			cert.ExtraExtensions = append(cert.ExtraExtensions, ext)
+		}
+
+		if profile.RequireExplicitPolicy != nil || profile.InhibitPolicyMapping != nil {
+			ext, err := policyConstraintsExtension(profile.RequireExplicitPolicy, profile.InhibitPolicyMapping)
+			if err != nil {
+				return fmt.Errorf("could not encode policy constraints: %w", err)
+			}
+
+			cert.ExtraExtensions = append(cert.ExtraExtensions, ext)
+		}
+
+		if profile.InhibitAnyPolicy != nil {
+			ext, err := inhibitAnyPolicyExtension(*profile.InhibitAnyPolicy)
+			if err != nil {
+				return fmt.Errorf("could not encode inhibit any policy: %w", err)
+			}
+
+			cert.ExtraExtensions = append(cert.ExtraExtensions, ext)
+		}
+
+		for _, ext := range profile.Extensions {
+			cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{
+				Id:       ext.OID(),
+				Critical: ext.Critical,
+				Value:    ext.Bytes(),
+			})
 		}
 
 		certBytes, err := x509.CreateCertificate(rand.Reader, cert, caCert, csr.PublicKey, caPrivateKey)
@@ -113,19 +255,20 @@ var signCSR = &cobra.Command{
 			return fmt.Errorf("could not sign certificate: %w", err)
 		}
 
-		cert.Raw = certBytes
+		cert, err = x509.ParseCertificate(certBytes)
+		if err != nil {
+			return fmt.Errorf("could not parse signed certificate: %w", err)
+		}
 
-		out := os.Stdout
-		if outputPath != "" {
-			file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+		if issued != nil {
+			err = issued.AppendCertificate(ctx, cert, issuance)
 			if err != nil {
-				return fmt.Errorf("could not create certificate path: %w", err)
+				return fmt.Errorf("could not append certificate to issuance database: %w", err)
 			}
-			defer file.Close()
-
-			out = file
 		}
 
+		committed = true
+
 		err = pem.Encode(out, &pem.Block{
 			Type:  "CERTIFICATE",
 			Bytes: certBytes,
@@ -134,10 +277,12 @@ var signCSR = &cobra.Command{
 			return fmt.Errorf("could not PEM-encode certificate: %w", err)
 		}
 
+		// the certificate has been issued, so failing to checkpoint must not
+		// fail the signing.
 		if issued != nil {
-			err = issued.AppendCertificate(ctx, cert)
+			err = autoCheckpoint(ctx, cfg.CheckpointInterval, caPrivateKey)
 			if err != nil {
-				return fmt.Errorf("could not append certificate to issuance database: %w", err)
+				fmt.Fprintf(os.Stderr, "Warning: could not checkpoint issuance database: %s\n", err)
 			}
 		}
 
@@ -147,11 +292,37 @@ var signCSR = &cobra.Command{
 
 func init() {
 	signCSR.Flags().StringVar(&csrPath, "csr", "csr.pem", "path to certificate signing request file")
-	signCSR.Flags().BoolVar(&isCA, "ca", false, "enable certificate as intermediate of certificate authority")
-	signCSR.Flags().BoolVar(&serverAuth, "server", false, "enable server authentication usage for key")
-	signCSR.Flags().BoolVar(&clientAuth, "client", false, "enable client authentication for key")
-	signCSR.Flags().StringVar(&validityStr, "validity", "8766h", "maximum period before certificate expires")
+	signCSR.Flags().StringVar(&profileName, "profile", "", "name of profile defining the shape of the certificate")
+	signCSR.Flags().StringVar(&validityStr, "validity", "", "period before certificate expires, up to the maximum of the profile")
 	signCSR.Flags().StringVar(&outputPath, "output", "", "write certificate to a file instead of stdout")
+	signCSR.Flags().StringVar(&requester, "requester", "", "who requested the certificate, recorded in the issuance database")
+
+	for _, name := range legacyProfiles {
+		signCSR.Flags().Bool(name, false, "sign with the built-in "+name+" profile")
+		signCSR.Flags().MarkDeprecated(name, "use --profile "+name+" instead")
+	}
+}
+
+// legacyProfiles are the flags that preceded --profile, each of which now
+// selects the built-in profile of the same name.
+var legacyProfiles = []string{"ca", "server", "client"}
+
+// resolveLegacyProfile sets profileName from any of legacyProfiles given to
+// cmd, which may only select a single profile.
+func resolveLegacyProfile(cmd *cobra.Command) error {
+	for _, name := range legacyProfiles {
+		if on, _ := cmd.Flags().GetBool(name); !on {
+			continue
+		}
+
+		if profileName != "" && profileName != name {
+			return fmt.Errorf("--%s selects the %s profile, which cannot be combined with the %s profile, define a profile with the usages of both and select it with --profile", name, name, profileName)
+		}
+
+		profileName = name
+	}
+
+	return nil
 }
 
 func readCSR(path string) (*x509.CertificateRequest, error) {
@@ -175,6 +346,230 @@ func readCSR(path string) (*x509.CertificateRequest, error) {
 	return csr, nil
 }
 
+func readCertificate(path string) (*x509.Certificate, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, fmt.Errorf("could not decode PEM block")
+	} else if block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("expected CERTIFICATE, got %q", block.Type)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+
+	return cert, nil
+}
+
+// checkCSR verifies the signature of csr, proving the requester possesses the
+// private key of the public key being certified, and that the public key and
+// signature algorithm are strong enough to be signed.
+func checkCSR(csr *x509.CertificateRequest) error {
+	switch csr.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.DSAWithSHA256, x509.ECDSAWithSHA1:
+		return fmt.Errorf("signature algorithm %s is not allowed", csr.SignatureAlgorithm)
+	}
+
+	err := csr.CheckSignature()
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	switch pub := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := pub.N.BitLen(); bits < 2048 {
+			return fmt.Errorf("RSA public key is %d bits, at least 2048 bits are required", bits)
+		}
+
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+
+		default:
+			return fmt.Errorf("ECDSA curve %s is not allowed, use P-256, P-384 or P-521", pub.Curve.Params().Name)
+		}
+
+	case ed25519.PublicKey:
+
+	default:
+		return fmt.Errorf("public key type %s is not allowed", csr.PublicKeyAlgorithm)
+	}
+
+	return nil
+}
+
+// checkSANs verifies that csr only requests the types of Subject Alternative
+// Name allowed by profile.
+func checkSANs(csr *x509.CertificateRequest, name string, profile *config.Profile) error {
+	if len(csr.DNSNames) > 0 && !profile.AllowsSAN(config.SANTypeDNS) {
+		return fmt.Errorf("DNS names are not allowed by profile %q", name)
+	}
+
+	if len(csr.IPAddresses) > 0 && !profile.AllowsSAN(config.SANTypeIP) {
+		return fmt.Errorf("IP addresses are not allowed by profile %q", name)
+	}
+
+	if len(csr.EmailAddresses) > 0 && !profile.AllowsSAN(config.SANTypeEmail) {
+		return fmt.Errorf("email addresses are not allowed by profile %q", name)
+	}
+
+	if len(csr.URIs) > 0 && !profile.AllowsSAN(config.SANTypeURI) {
+		return fmt.Errorf("URIs are not allowed by profile %q", name)
+	}
+
+	return nil
+}
+
+// crlDistributionPoints returns the URLs of the complete and delta
+// Certificate Revocation Lists covering a certificate with serial signed with
+// profile. Certificates point to the crl of their partition, otherwise that of
+// their profile, falling back to the complete crl.
+func crlDistributionPoints(cfg *config.CA, serial *big.Int, name string, profile *config.Profile) (crl, deltaCRL []string) {
+	if _, partition, ok := cfg.CRLPartition(serial, name); ok {
+		return partition.CRL, partition.DeltaCRL
+	}
+
+	if profile.CRL != nil {
+		return profile.CRL, cfg.DeltaCRL
+	}
+
+	return cfg.CRL, cfg.DeltaCRL
+}
+
+// autoCheckpoint signs a checkpoint of the issuance database with signer once
+// interval entries have been appended since the last checkpoint.
+func autoCheckpoint(ctx context.Context, interval int, signer crypto.Signer) error {
+	verifier, ok := issued.(db.Verifier)
+	if interval <= 0 || !ok {
+		return nil
+	}
+
+	n, err := verifier.Uncheckpointed(ctx)
+	if err != nil {
+		return err
+	} else if n < interval {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Signing checkpoint of issuance database...\n")
+
+	_, err = verifier.Checkpoint(ctx, signer)
+	return err
+}
+
+// currentOperator returns the name of the user running yubca, recorded in the
+// issuance database as the operator of a signing.
+func currentOperator() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// maxSerialAttempts is the number of serials drawn from the counter before
+// giving up on finding one that is not already in the issuance database.
+const maxSerialAttempts = 16
+
+// reserveSerial assigns a serial to a certificate signed as described by
+// issuance, using the scheme configured for the Certificate Authority, and
+// reserves it in the issuance database if one is configured.
+func reserveSerial(ctx context.Context, cfg *config.CA, issuance *db.Issuance) (*big.Int, error) {
+	scheme := config.SerialRandom
+
+	var prefix []byte
+	if cfg.Serial != nil {
+		if cfg.Serial.Scheme != "" {
+			scheme = cfg.Serial.Scheme
+		}
+
+		prefix, _ = cfg.Serial.PrefixBytes()
+	}
+
+	if issued == nil {
+		if scheme == config.SerialCounter {
+			return nil, fmt.Errorf("an issuance database is required for counter serials, use --db")
+		}
+
+		serial, err := randomSerial()
+		if err != nil {
+			return nil, fmt.Errorf("could not generate random serial: %w", err)
+		}
+
+		return prefixSerial(prefix, serial, 16), nil
+	}
+
+	for i := 0; i < maxSerialAttempts; i++ {
+		var serial *big.Int
+
+		switch scheme {
+		case config.SerialCounter:
+			n, err := issued.Increment(ctx, "serial")
+			if err != nil {
+				return nil, fmt.Errorf("could not increment serial counter: %w", err)
+			}
+
+			serial = prefixSerial(prefix, n, 8)
+
+		default:
+			n, err := randomSerial()
+			if err != nil {
+				return nil, fmt.Errorf("could not generate random serial: %w", err)
+			}
+
+			serial = prefixSerial(prefix, n, 16)
+		}
+
+		err := issued.Reserve(ctx, serial, issuance)
+		if errors.Is(err, db.ErrExists) {
+			// 128 random bits should never collide, so a repeat is more
+			// likely a broken random number generator than bad luck.
+			if scheme != config.SerialCounter {
+				return nil, fmt.Errorf("random serial %s is already in the issuance database, the random number generator may be broken", db.SerialString(serial))
+			}
+
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("could not reserve serial in issuance database: %w", err)
+		}
+
+		return serial, nil
+	}
+
+	return nil, fmt.Errorf("could not find an unused serial after %d attempts, the serial counter is behind the issuance database", maxSerialAttempts)
+}
+
+// abandonSerial marks the reserved serial as abandoned, unless the certificate
+// signed with it was committed, so an error after reserving it does not leave
+// it pending.
+func abandonSerial(ctx context.Context, serial *big.Int, committed *bool) {
+	if issued == nil || *committed {
+		return
+	}
+
+	if err := issued.Abandon(ctx, serial); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not abandon reserved serial %s: %s\n", db.SerialString(serial), err)
+	}
+}
+
+// prefixSerial returns serial, as a number of width bytes, following prefix.
+func prefixSerial(prefix []byte, serial *big.Int, width int) *big.Int {
+	if len(prefix) < 1 {
+		return serial
+	}
+
+	n := new(big.Int).SetBytes(prefix)
+	n.Lsh(n, uint(width*8))
+
+	return n.Or(n, serial)
+}
+
 // randomSerial generates a random 16-byte big.Int to be used for the serial
 // number of a Certificate.
 func randomSerial() (*big.Int, error) {
@@ -0,0 +1,404 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jamescun/yubca/config"
+	"github.com/jamescun/yubca/db"
+	"github.com/spf13/cobra"
+)
+
+func TestCheckCSR(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    func() (crypto.Signer, error)
+		tamper bool
+		err    string
+	}{
+		{
+			name: "ECDSA",
+			key:  func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+		},
+		{
+			name: "Ed25519",
+			key: func() (crypto.Signer, error) {
+				_, key, err := ed25519.GenerateKey(rand.Reader)
+				return key, err
+			},
+		},
+		{
+			name: "RSA",
+			key:  func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) },
+		},
+		{
+			name:   "BadSignature",
+			key:    func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+			tamper: true,
+			err:    "invalid signature",
+		},
+		{
+			name: "SmallRSA",
+			key:  func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 1024) },
+			err:  "RSA public key is 1024 bits, at least 2048 bits are required",
+		},
+		{
+			name: "UnsupportedCurve",
+			key:  func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P224(), rand.Reader) },
+			err:  "ECDSA curve P-224 is not allowed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := test.key()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+				Subject: pkix.Name{CommonName: "www.example.org"},
+			}, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.tamper {
+				// the signature is the last field of the request.
+				der[len(der)-1] ^= 0xff
+			}
+
+			csr, err := x509.ParseCertificateRequest(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkCSR(csr)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestResolveLegacyProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		profile string
+		err     string
+	}{
+		{"Profile", []string{"--profile", "device"}, "device", ""},
+		{"CA", []string{"--ca"}, "ca", ""},
+		{"Server", []string{"--server"}, "server", ""},
+		{"SameProfile", []string{"--client", "--profile", "client"}, "client", ""},
+		{"Disabled", []string{"--server=false", "--profile", "device"}, "device", ""},
+		{"ServerAndClient", []string{"--server", "--client"}, "", "--client selects the client profile, which cannot be combined with the server profile"},
+		{"OtherProfile", []string{"--ca", "--profile", "device"}, "", "--ca selects the ca profile, which cannot be combined with the device profile"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profileName = ""
+
+			cmd := &cobra.Command{}
+			cmd.Flags().StringVar(&profileName, "profile", "", "")
+			for _, name := range legacyProfiles {
+				cmd.Flags().Bool(name, false, "")
+			}
+
+			err := cmd.ParseFlags(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = resolveLegacyProfile(cmd)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if profileName != test.profile {
+				t.Errorf("expected profile %q, got %q", test.profile, profileName)
+			}
+		})
+	}
+}
+
+func TestReserveSerial(t *testing.T) {
+	tests := []struct {
+		name   string
+		serial *config.Serial
+		bits   int
+		prefix int64
+	}{
+		{"Random", nil, 128, 0},
+		{"RandomPrefix", &config.Serial{Prefix: "7f:01:02"}, 16 * 8, 0x7f0102},
+		{"Counter", &config.Serial{Scheme: config.SerialCounter}, 64, 0},
+		{"CounterPrefix", &config.Serial{Scheme: config.SerialCounter, Prefix: "0a"}, 8 * 8, 0x0a},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestDB(t)
+
+			cfg := &config.CA{Serial: test.serial}
+
+			for i := 0; i < 3; i++ {
+				serial, err := reserveSerial(context.Background(), cfg, &db.Issuance{Profile: "server"})
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+
+				// serials must be positive and fit in the 20 octets allowed by
+				// RFC 5280, which leaves 159 bits for the magnitude.
+				if serial.Sign() <= 0 || serial.BitLen() > 159 {
+					t.Fatalf("serial %s is not a positive number of at most 20 octets", serial)
+				}
+
+				value := new(big.Int).Rsh(serial, uint(test.bits))
+				if value.Int64() != test.prefix {
+					t.Errorf("expected serial %x to follow prefix %x", serial, test.prefix)
+				}
+
+				if test.serial != nil && test.serial.Scheme == config.SerialCounter {
+					count := new(big.Int).Sub(serial, new(big.Int).Lsh(value, uint(test.bits)))
+					if count.Int64() != int64(i+1) {
+						t.Errorf("expected counter serial %d, got %s", i+1, count)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReserveSerialExisting(t *testing.T) {
+	ctx := context.Background()
+
+	useTestDB(t)
+
+	// serials reserved ahead of the counter, such as when it was reset or
+	// imported behind the database, must be skipped.
+	for i := int64(1); i <= 2; i++ {
+		err := issued.Reserve(ctx, big.NewInt(i), &db.Issuance{Profile: "server"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.CA{Serial: &config.Serial{Scheme: config.SerialCounter}}
+
+	serial, err := reserveSerial(ctx, cfg, &db.Issuance{Profile: "server"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if serial.Int64() != 3 {
+		t.Errorf("expected serial 3, got %s", serial)
+	}
+
+	for i := int64(4); i <= maxSerialAttempts+3; i++ {
+		err = issued.Reserve(ctx, big.NewInt(i), &db.Issuance{Profile: "server"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = reserveSerial(ctx, cfg, &db.Issuance{Profile: "server"})
+	if err == nil || !strings.Contains(err.Error(), "could not find an unused serial") {
+		t.Errorf("expected error after %d attempts, got %v", maxSerialAttempts, err)
+	}
+}
+
+// useTestDB sets the issuance database to an empty JSON database for the
+// duration of the test.
+func useTestDB(t *testing.T) {
+	t.Helper()
+
+	j, err := db.NewJSON(filepath.Join(t.TempDir(), "issued.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := issued
+	issued = j
+
+	t.Cleanup(func() {
+		issued.Close()
+		issued = prev
+	})
+}
+
+func TestSignKeyIdentifiers(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+	}{
+		{"Default", ""},
+		{"SHA1", config.KeyIDSHA1},
+		{"SHA256", config.KeyIDSHA256},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ca := newSoftwareCA(t, `"subjectKeyIdMethod": "`+test.method+`",
+				"ocsp": [ "http://example.org/ocsp" ],
+				"caIssuers": [ "http://example.org/ca.crt" ]`)
+
+			caID, err := subjectKeyID(ca.cert.PublicKey, test.method)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a root identifies itself as its own authority.
+			if !bytes.Equal(ca.cert.SubjectKeyId, caID) || !bytes.Equal(ca.cert.AuthorityKeyId, caID) {
+				t.Errorf("expected certificate authority key ids %x, got subject %x and authority %x", caID, ca.cert.SubjectKeyId, ca.cert.AuthorityKeyId)
+			}
+
+			cert := ca.sign("server")
+
+			id, err := subjectKeyID(cert.PublicKey, test.method)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(cert.SubjectKeyId, id) {
+				t.Errorf("expected subject key id %x, got %x", id, cert.SubjectKeyId)
+			}
+
+			if !bytes.Equal(cert.AuthorityKeyId, caID) {
+				t.Errorf("expected authority key id %x, got %x", caID, cert.AuthorityKeyId)
+			}
+
+			if !reflect.DeepEqual(cert.OCSPServer, []string{"http://example.org/ocsp"}) {
+				t.Errorf("unexpected ocsp servers %q", cert.OCSPServer)
+			}
+
+			if !reflect.DeepEqual(cert.IssuingCertificateURL, []string{"http://example.org/ca.crt"}) {
+				t.Errorf("unexpected issuing certificate urls %q", cert.IssuingCertificateURL)
+			}
+		})
+	}
+}
+
+func TestCRLDistributionPoints(t *testing.T) {
+	cfg := &config.CA{
+		CRL:      []string{"http://example.org/ca.crl"},
+		DeltaCRL: []string{"http://example.org/ca-delta.crl"},
+		CRLPartitions: map[string]*config.CRLPartition{
+			"web": {
+				CRL:      []string{"http://example.org/web.crl"},
+				DeltaCRL: []string{"http://example.org/web-delta.crl"},
+				Profiles: []string{"server"},
+			},
+			"legacy": {
+				CRL:       []string{"http://example.org/legacy.crl"},
+				SerialMin: "01",
+				SerialMax: "ff",
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		cfg        *config.CA
+		serial     int64
+		profile    string
+		crl        []string
+		deltaCRL   []string
+		profileCRL []string
+	}{
+		{"None", &config.CA{}, 0x1000, "client", nil, nil, nil},
+		{"CA", cfg, 0x1000, "client", []string{"http://example.org/ca.crl"}, []string{"http://example.org/ca-delta.crl"}, nil},
+		{"Profile", cfg, 0x1000, "client", []string{"http://example.org/client.crl"}, []string{"http://example.org/ca-delta.crl"}, []string{"http://example.org/client.crl"}},
+		{"ProfileWithout", cfg, 0x1000, "client", []string{}, []string{"http://example.org/ca-delta.crl"}, []string{}},
+		{"PartitionByProfile", cfg, 0x1000, "server", []string{"http://example.org/web.crl"}, []string{"http://example.org/web-delta.crl"}, []string{"http://example.org/client.crl"}},
+		{"PartitionBySerial", cfg, 0x10, "client", []string{"http://example.org/legacy.crl"}, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profile := &config.Profile{CRL: test.profileCRL}
+
+			crl, deltaCRL := crlDistributionPoints(test.cfg, big.NewInt(test.serial), test.profile, profile)
+
+			if !reflect.DeepEqual(crl, test.crl) {
+				t.Errorf("expected crl %q, got %q", test.crl, crl)
+			}
+
+			if !reflect.DeepEqual(deltaCRL, test.deltaCRL) {
+				t.Errorf("expected delta crl %q, got %q", test.deltaCRL, deltaCRL)
+			}
+		})
+	}
+}
+
+func TestSignCRLDistributionPoints(t *testing.T) {
+	ca := newSoftwareCA(t, `"crl": [ "http://example.org/ca.crl" ],
+		"crlPartitions": {
+			"web": { "crl": [ "http://example.org/web.crl" ], "deltaCrl": [ "http://example.org/web-delta.crl" ], "profiles": [ "server" ] }
+		}`)
+
+	tests := []struct {
+		name     string
+		profile  string
+		crl      []string
+		deltaCRL []string
+	}{
+		{"Complete", "client", []string{"http://example.org/ca.crl"}, nil},
+		{"Partition", "server", []string{"http://example.org/web.crl"}, []string{"http://example.org/web-delta.crl"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cert := ca.sign(test.profile)
+
+			if !reflect.DeepEqual(cert.CRLDistributionPoints, test.crl) {
+				t.Errorf("expected crl distribution points %q, got %q", test.crl, cert.CRLDistributionPoints)
+			}
+
+			var freshest *pkix.Extension
+			for i, ext := range cert.Extensions {
+				if ext.Id.Equal(oidExtensionFreshestCRL) {
+					freshest = &cert.Extensions[i]
+				}
+			}
+
+			if test.deltaCRL == nil {
+				if freshest != nil {
+					t.Errorf("unexpected freshest crl")
+				}
+				return
+			} else if freshest == nil {
+				t.Fatalf("expected freshest crl %q", test.deltaCRL)
+			}
+
+			expected, err := freshestCRLExtension(test.deltaCRL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertExtension(t, *freshest, expected.Id, expected.Critical, hex.EncodeToString(expected.Value))
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/jamescun/yubca/backend"
+	"github.com/jamescun/yubca/db"
+)
+
+// TestSoftwareLifecycle runs a Certificate Authority on the Software backend
+// from init through signing and revoking a certificate to generating its CRL.
+func TestSoftwareLifecycle(t *testing.T) {
+	ca := newSoftwareCA(t, `"crl": [ "http://example.org/ca.crl" ]`)
+
+	keyPEM, err := os.ReadFile(ca.path("keys", "ca.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block, _ := pem.Decode(keyPEM); block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("expected private key to be encrypted with passphrase")
+	}
+
+	if !ca.cert.IsCA || ca.cert.Subject.CommonName != "Test Root" {
+		t.Fatalf("unexpected certificate authority %q", ca.cert.Subject)
+	}
+
+	cert := ca.sign("server")
+
+	err = cert.CheckSignatureFrom(ca.cert)
+	if err != nil {
+		t.Fatalf("signed certificate not signed by certificate authority: %s", err)
+	}
+
+	if len(cert.CRLDistributionPoints) != 1 || cert.CRLDistributionPoints[0] != "http://example.org/ca.crl" {
+		t.Errorf("unexpected crl distribution points %q", cert.CRLDistributionPoints)
+	}
+
+	ca.run("revoke", "--cert", ca.path("leaf.pem"), "--reason", "keyCompromise")
+
+	crl := ca.crl()
+
+	if n := len(crl.RevokedCertificateEntries); n != 1 {
+		t.Fatalf("expected 1 revoked certificate, got %d", n)
+	}
+
+	entry := crl.RevokedCertificateEntries[0]
+	if entry.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Errorf("expected revoked serial %s, got %s", db.SerialString(cert.SerialNumber), db.SerialString(entry.SerialNumber))
+	}
+
+	if entry.ReasonCode != 1 {
+		t.Errorf("expected reason keyCompromise, got %d", entry.ReasonCode)
+	}
+}
+
+// softwareCA is a Certificate Authority on the Software backend, initialized
+// in a temporary directory with a JSON issuance database.
+type softwareCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+}
+
+// newSoftwareCA initializes a root Certificate Authority called Test Root,
+// with the additional fields of its configuration given in extra.
+func newSoftwareCA(t *testing.T, extra string) *softwareCA {
+	t.Helper()
+
+	ca := &softwareCA{t: t, dir: t.TempDir()}
+
+	t.Setenv("YUBCA_TEST_PASSPHRASE", "correct horse battery staple")
+
+	if extra != "" {
+		extra = ",\n" + extra
+	}
+
+	writeFile(t, ca.path("ca.json"), `{
+		"backend": "software",
+		"software": { "path": "`+filepath.ToSlash(ca.path("keys"))+`" },
+		"slot": "ca",
+		"algorithm": "ec256",
+		"subject": { "CN": "Test Root" },
+		"validity": "8760h"`+extra+`
+	}`)
+
+	ca.run("init")
+	ca.cert = softwareCertificate(t, ca.path("keys"), "ca")
+
+	return ca
+}
+
+// path returns the path of name within the directory of the Certificate
+// Authority.
+func (ca *softwareCA) path(name ...string) string {
+	return filepath.Join(append([]string{ca.dir}, name...)...)
+}
+
+// run executes the command given by args against the Certificate Authority,
+// failing the test if it returns an error.
+func (ca *softwareCA) run(args ...string) {
+	ca.t.Helper()
+
+	if err := ca.exec(args...); err != nil {
+		ca.t.Fatalf("%s: %s", args[0], err)
+	}
+}
+
+// exec executes the command given by args against the Certificate Authority,
+// with every other flag reset to its default as cobra retains them between
+// executions.
+func (ca *softwareCA) exec(args ...string) error {
+	resetFlags(root)
+
+	args = append(args, "--config", ca.path("ca.json"), "--db", ca.path("issued.json"), "--passphrase-from", "env:YUBCA_TEST_PASSPHRASE")
+
+	root.SetArgs(args)
+	return root.ExecuteContext(context.Background())
+}
+
+// sign signs a certificate for www.example.org with profile, and any further
+// flags given in args.
+func (ca *softwareCA) sign(profile string, args ...string) *x509.Certificate {
+	ca.t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+
+	return ca.signKey(key, profile, args...)
+}
+
+// signKey signs a certificate for www.example.org and the public key of key
+// with profile, and any further flags given in args.
+func (ca *softwareCA) signKey(key crypto.Signer, profile string, args ...string) *x509.Certificate {
+	ca.t.Helper()
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "www.example.org"},
+		DNSNames: []string{"www.example.org"},
+	}, key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+
+	writeFile(ca.t, ca.path("leaf.csr"), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})))
+
+	ca.run(append([]string{"sign", "--csr", ca.path("leaf.csr"), "--profile", profile, "--output", ca.path("leaf.pem")}, args...)...)
+
+	cert, err := readCertificate(ca.path("leaf.pem"))
+	if err != nil {
+		ca.t.Fatalf("could not read signed certificate: %s", err)
+	}
+
+	return cert
+}
+
+// crl generates a Certificate Revocation List with any further flags given in
+// args, verifying it was signed by the Certificate Authority.
+func (ca *softwareCA) crl(args ...string) *x509.RevocationList {
+	ca.t.Helper()
+
+	ca.run(append([]string{"crl", "--output", ca.path("ca.crl"), "--format", "der"}, args...)...)
+
+	crlBytes, err := os.ReadFile(ca.path("ca.crl"))
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+
+	crl, err := x509.ParseRevocationList(crlBytes)
+	if err != nil {
+		ca.t.Fatalf("could not parse crl: %s", err)
+	}
+
+	err = crl.CheckSignatureFrom(ca.cert)
+	if err != nil {
+		ca.t.Fatalf("crl not signed by certificate authority: %s", err)
+	}
+
+	return crl
+}
+
+// resetFlags sets every flag of cmd and its subcommands to its default.
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		f.Value.Set(f.DefValue)
+		f.Changed = false
+	}
+
+	cmd.PersistentFlags().VisitAll(reset)
+	cmd.Flags().VisitAll(reset)
+
+	for _, sub := range cmd.Commands() {
+		resetFlags(sub)
+	}
+}
+
+// softwareCertificate reads the certificate stored in slot of the Software
+// backend at path.
+func softwareCertificate(t *testing.T, path, slot string) *x509.Certificate {
+	t.Helper()
+
+	b, err := backend.NewSoftware(path, &backend.Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	cert, err := b.Certificate(slot)
+	if err != nil {
+		t.Fatalf("could not read certificate from slot %q: %s", slot, err)
+	}
+
+	return cert
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	err := os.WriteFile(path, []byte(data), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,5 +1,18 @@
 package config
 
+import (
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Methods of computing the Subject Key Identifier of a certificate.
+const (
+	KeyIDSHA1   = "sha1"
+	KeyIDSHA256 = "sha256"
+)
+
 // DN is a Distinguished Name as defined by RFC 4514 Section 3.
 type DN struct {
 	C  []string `json:"C"`
@@ -22,9 +35,87 @@ func (dn *DN) Validate() error {
 	return nil
 }
 
+// YubiKey configures a Certificate Authority stored in the PIV slots of a
+// YubiKey.
+type YubiKey struct {
+	// PINProtectedManagementKey reads the management key from the metadata
+	// stored on the YubiKey, protected by the PIN, rather than prompting for
+	// it.
+	PINProtectedManagementKey bool `json:"pinProtectedManagementKey"`
+}
+
+// Software configures a Certificate Authority stored in files on disk rather
+// than on a YubiKey, intended for development and testing.
+type Software struct {
+	// Path is the directory where the private key and certificate are stored,
+	// named after the slot of the Certificate Authority.
+	Path string `json:"path"`
+}
+
+func (s *Software) Validate() error {
+	if s.Path == "" {
+		return &ValidationError{
+			Field:   "software.path",
+			Help:    "The software backend requires a directory to store the private key and\ncertificate of your certificate authority.",
+			Message: "path is required",
+		}
+	}
+
+	return nil
+}
+
+// PKCS11 configures a Certificate Authority stored on a token accessed through
+// a PKCS#11 module, such as a network HSM or SoftHSM.
+type PKCS11 struct {
+	// Module is the path to the PKCS#11 shared library of the token.
+	Module string `json:"module"`
+
+	// Token is the label of the token storing the Certificate Authority.
+	Token string `json:"token"`
+
+	// Key is the label of the private key, public key and certificate objects
+	// of the Certificate Authority on the token. If empty, the slot is used.
+	Key string `json:"key"`
+}
+
+func (p *PKCS11) Validate() error {
+	if p.Module == "" {
+		return &ValidationError{
+			Field:   "pkcs11.module",
+			Help:    "The path to the PKCS#11 shared library provided by your HSM vendor,\nsuch as /usr/lib/softhsm/libsofthsm2.so.",
+			Message: "module is required",
+		}
+	}
+
+	if p.Token == "" {
+		return &ValidationError{
+			Field:   "pkcs11.token",
+			Help:    "The label of the token on which your certificate authority is stored.",
+			Message: "token is required",
+		}
+	}
+
+	return nil
+}
+
 // CA configured the parameters for initializing and operation a Certificate
 // Authority from a YubiKey.
 type CA struct {
+	// Backend is the type of key store that holds the private key and
+	// certificate of the Certificate Authority. If empty, a YubiKey is used.
+	Backend string `json:"backend"`
+
+	// YubiKey configures the yubikey backend.
+	YubiKey *YubiKey `json:"yubikey"`
+
+	// Software configures the software backend, which stores the private key
+	// and certificate of the Certificate Authority in files on disk.
+	Software *Software `json:"software"`
+
+	// PKCS11 configures the pkcs11 backend, which stores the private key and
+	// certificate of the Certificate Authority on a token such as an HSM.
+	PKCS11 *PKCS11 `json:"pkcs11"`
+
 	// Slot is the PIV slot on the YubiKey that will store the certificate
 	// authority and private key.
 	Slot string `json:"slot"`
@@ -41,12 +132,109 @@ type CA struct {
 	// expire.
 	Validity string `json:"validity"`
 
+	// MaxPathLen is the maximum number of intermediate Certificate
+	// Authorities that may follow a root initialized by yubca. If omitted,
+	// the path length is unconstrained.
+	MaxPathLen *int `json:"maxPathLen"`
+
+	// NameConstraints restricts the names of the certificates that may be
+	// signed beneath a root initialized by yubca.
+	NameConstraints *NameConstraints `json:"nameConstraints"`
+
+	// Policies are the certificate policies of a root initialized by yubca,
+	// and of the certificates it signs whose profile has none of its own.
+	Policies []*Policy `json:"policies"`
+
 	// CRL is an array of URLs pointing to where the Certificate Revocation
 	// Lists generated by yubca can be accessed.
 	CRL []string `json:"crl"`
+
+	// DeltaCRL is an array of URLs pointing to where the delta Certificate
+	// Revocation Lists generated by yubca can be accessed, added to signed
+	// certificates as their Freshest CRL.
+	DeltaCRL []string `json:"deltaCrl"`
+
+	// CRLPartitions divide the certificates signed by the Certificate
+	// Authority between multiple Certificate Revocation Lists, each published
+	// at their own URLs.
+	CRLPartitions map[string]*CRLPartition `json:"crlPartitions"`
+
+	// CRLValidity is the duration of time a Certificate Revocation List is
+	// valid for before clients should fetch a new one. Defaults to 168h.
+	CRLValidity string `json:"crlValidity"`
+
+	// OCSP is an array of URLs pointing to where the OCSP responder of the
+	// Certificate Authority can be reached, added to signed certificates as
+	// their Authority Information Access.
+	OCSP []string `json:"ocsp"`
+
+	// CAIssuers is an array of URLs pointing to where the certificate of the
+	// Certificate Authority can be downloaded, added to signed certificates
+	// as their Authority Information Access.
+	CAIssuers []string `json:"caIssuers"`
+
+	// SubjectKeyIDMethod is how the Subject Key Identifier of certificates
+	// is computed from their public key, either sha1 or sha256. If empty,
+	// sha1 is used.
+	SubjectKeyIDMethod string `json:"subjectKeyIdMethod"`
+
+	// OCSPValidity is the duration of time an OCSP response is valid for
+	// before clients should request a new one. Defaults to 24h.
+	OCSPValidity string `json:"ocspValidity"`
+
+	// Serial configures how serial numbers are assigned to signed
+	// certificates. If nil, they are random.
+	Serial *Serial `json:"serial"`
+
+	// CheckpointInterval is the number of entries appended to a JSON issuance
+	// database after which sign will also sign a checkpoint of it, requiring
+	// an additional touch. If zero, checkpoints are only signed by
+	// `yubca db checkpoint`.
+	CheckpointInterval int `json:"checkpointInterval"`
+
+	// Profiles are the named shapes of certificate that can be signed by the
+	// Certificate Authority, in addition to DefaultProfiles.
+	Profiles map[string]*Profile `json:"profiles"`
 }
 
 func (ca *CA) Validate() error {
+	switch ca.Backend {
+	case "", "yubikey":
+
+	case "software":
+		if ca.Software == nil {
+			return &ValidationError{
+				Field:   "software",
+				Help:    "The software backend stores the private key and certificate of your\ncertificate authority in the directory given by path.",
+				Message: "software is required for the software backend",
+			}
+		}
+
+		if err := ca.Software.Validate(); err != nil {
+			return err
+		}
+
+	case "pkcs11":
+		if ca.PKCS11 == nil {
+			return &ValidationError{
+				Field:   "pkcs11",
+				Help:    "The pkcs11 backend stores the private key and certificate of your\ncertificate authority on a token accessed through a PKCS#11 module.",
+				Message: "pkcs11 is required for the pkcs11 backend",
+			}
+		}
+
+		if err := ca.PKCS11.Validate(); err != nil {
+			return err
+		}
+
+	default:
+		return &ValidationError{
+			Field:   "backend",
+			Help:    "The backend defines where the private key and certificate of your\ncertificate authority are stored. Valid values include yubikey, software\nand pkcs11.",
+			Message: "unknown backend",
+		}
+	}
+
 	if ca.Slot == "" {
 		return &ValidationError{
 			Field:   "slot",
@@ -82,9 +270,141 @@ func (ca *CA) Validate() error {
 		}
 	}
 
+	if ca.MaxPathLen != nil && *ca.MaxPathLen < 0 {
+		return &ValidationError{
+			Field:   "maxPathLen",
+			Message: "maxPathLen must not be negative",
+		}
+	}
+
+	if ca.NameConstraints != nil {
+		if err := ca.NameConstraints.Validate("nameConstraints"); err != nil {
+			return err
+		}
+	}
+
+	for i, policy := range ca.Policies {
+		if policy == nil {
+			return &ValidationError{
+				Field:   "policies." + strconv.Itoa(i),
+				Message: "policy must not be null",
+			}
+		}
+
+		if err := policy.Validate("policies." + strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+
+	if ca.CRLValidity != "" {
+		if _, err := time.ParseDuration(ca.CRLValidity); err != nil {
+			return &ValidationError{
+				Field:   "crlValidity",
+				Help:    "The validity of a certificate revocation list can be express is ns, ms, s, m or h.",
+				Message: err.Error(),
+			}
+		}
+	}
+
+	switch ca.SubjectKeyIDMethod {
+	case "", KeyIDSHA1, KeyIDSHA256:
+
+	default:
+		return &ValidationError{
+			Field:   "subjectKeyIdMethod",
+			Help:    "The subject key identifier is computed with sha1, the SHA-1 hash of the public\nkey (RFC 5280 Section 4.2.1.2), or sha256, the leftmost 160 bits of its\nSHA-256 hash (RFC 7093 Section 2).",
+			Message: "unknown method",
+		}
+	}
+
+	if ca.OCSPValidity != "" {
+		if _, err := time.ParseDuration(ca.OCSPValidity); err != nil {
+			return &ValidationError{
+				Field:   "ocspValidity",
+				Help:    "The validity of an ocsp response can be express is ns, ms, s, m or h.",
+				Message: err.Error(),
+			}
+		}
+	}
+
+	if ca.Serial != nil {
+		if err := ca.Serial.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if ca.CheckpointInterval < 0 {
+		return &ValidationError{
+			Field:   "checkpointInterval",
+			Help:    "The checkpoint interval is a number of entries, or zero to disable automatic checkpoints.",
+			Message: "checkpointInterval cannot be negative",
+		}
+	}
+
+	for _, name := range sortedKeys(ca.CRLPartitions) {
+		if ca.CRLPartitions[name] == nil {
+			return &ValidationError{
+				Field:   "crlPartitions." + name,
+				Message: "partition must not be null",
+			}
+		}
+
+		if err := ca.CRLPartitions[name].Validate(name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sortedKeys(ca.Profiles) {
+		if ca.Profiles[name] == nil {
+			return &ValidationError{
+				Field:   "profiles." + name,
+				Message: "profile must not be null",
+			}
+		}
+
+		if err := ca.Profiles[name].Validate(name); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Profile returns the profile called name from the configuration of the
+// Certificate Authority, or from DefaultProfiles.
+func (ca *CA) Profile(name string) (*Profile, bool) {
+	if profile, ok := ca.Profiles[name]; ok {
+		return profile, true
+	}
+
+	profile, ok := DefaultProfiles[name]
+	return profile, ok
+}
+
+// CRLPartition returns the name and configuration of the partition of
+// Certificate Revocation Lists that a certificate with serial, signed with
+// profile, belongs to. If it belongs to no partition, false is returned.
+func (ca *CA) CRLPartition(serial *big.Int, profile string) (string, *CRLPartition, bool) {
+	for _, name := range sortedKeys(ca.CRLPartitions) {
+		if partition := ca.CRLPartitions[name]; partition.Contains(serial, profile) {
+			return name, partition, true
+		}
+	}
+
+	return "", nil, false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
 // ValidationError is returned when validation of a Certificate Authority's
 // configuration fails.
 type ValidationError struct {
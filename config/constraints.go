@@ -0,0 +1,111 @@
+package config
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NameConstraints restricts the names a Certificate Authority may sign
+// certificates for, as defined by RFC 5280 Section 4.2.1.10. They are always
+// marked critical.
+type NameConstraints struct {
+	// Permitted are the only names certificates may contain, of each type
+	// listed. Types with no names listed are not restricted.
+	Permitted *NameSubtrees `json:"permitted"`
+
+	// Excluded are names certificates must not contain, even if permitted.
+	Excluded *NameSubtrees `json:"excluded"`
+}
+
+func (nc *NameConstraints) Validate(field string) error {
+	if nc.Permitted != nil {
+		if err := nc.Permitted.Validate(field + ".permitted"); err != nil {
+			return err
+		}
+	}
+
+	if nc.Excluded != nil {
+		if err := nc.Excluded.Validate(field + ".excluded"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NameSubtrees are the names of each type permitted or excluded by
+// NameConstraints.
+type NameSubtrees struct {
+	// DNS are domains, such as example.org, which also match their
+	// subdomains. A leading period, such as .example.org, matches only
+	// subdomains.
+	DNS []string `json:"dns"`
+
+	// IP are ranges of IP addresses in CIDR notation, such as 10.0.0.0/8.
+	IP []string `json:"ip"`
+
+	// Email are mailboxes, such as admin@example.org, or domains matching
+	// every mailbox at that host. A leading period matches only subdomains.
+	Email []string `json:"email"`
+
+	// URI are hosts matching the host of URIs exactly, such as example.org. A
+	// leading period matches only subdomains.
+	URI []string `json:"uri"`
+}
+
+func (ns *NameSubtrees) Validate(field string) error {
+	for _, dns := range ns.DNS {
+		if dns == "" || dns == "." || strings.ContainsAny(dns, "@/ ") {
+			return &ValidationError{
+				Field:   field + ".dns",
+				Help:    "DNS constraints are domains such as example.org, or .example.org to match\nonly its subdomains.",
+				Message: "invalid domain " + strconv.Quote(dns),
+			}
+		}
+	}
+
+	for _, ip := range ns.IP {
+		if _, _, err := net.ParseCIDR(ip); err != nil {
+			return &ValidationError{
+				Field:   field + ".ip",
+				Help:    "IP constraints are ranges in CIDR notation, such as 10.0.0.0/8 or fd00::/8.",
+				Message: err.Error(),
+			}
+		}
+	}
+
+	for _, email := range ns.Email {
+		if email == "" || strings.Count(email, "@") > 1 || strings.HasSuffix(email, "@") {
+			return &ValidationError{
+				Field:   field + ".email",
+				Help:    "Email constraints are mailboxes such as admin@example.org, or domains such\nas example.org to match every mailbox at that host.",
+				Message: "invalid email " + strconv.Quote(email),
+			}
+		}
+	}
+
+	for _, uri := range ns.URI {
+		if uri == "" || uri == "." || strings.ContainsAny(uri, "@/: ") {
+			return &ValidationError{
+				Field:   field + ".uri",
+				Help:    "URI constraints are domains matching the host of URIs, such as example.org,\nor .example.org to match only its subdomains.",
+				Message: "invalid domain " + strconv.Quote(uri),
+			}
+		}
+	}
+
+	return nil
+}
+
+// IPNets returns the parsed IP ranges of the subtrees.
+func (ns *NameSubtrees) IPNets() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, ip := range ns.IP {
+		if _, ipNet, err := net.ParseCIDR(ip); err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+
+	return nets
+}
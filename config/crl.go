@@ -0,0 +1,93 @@
+package config
+
+import (
+	"math/big"
+	"strings"
+)
+
+// CRLPartition configures a subset of the certificates signed by a
+// Certificate Authority whose revocations are published in their own
+// Certificate Revocation List. Certificates belong to the first partition,
+// ordered by name, matching either their profile or serial number.
+type CRLPartition struct {
+	// CRL is an array of URLs pointing to where the Certificate Revocation
+	// Lists of this partition can be accessed.
+	CRL []string `json:"crl"`
+
+	// DeltaCRL is an array of URLs pointing to where the delta Certificate
+	// Revocation Lists of this partition can be accessed.
+	DeltaCRL []string `json:"deltaCrl"`
+
+	// Profiles are the names of the profiles whose certificates belong to
+	// this partition.
+	Profiles []string `json:"profiles"`
+
+	// SerialMin and SerialMax are the hex-encoded inclusive range of serial
+	// numbers of certificates belonging to this partition.
+	SerialMin string `json:"serialMin"`
+	SerialMax string `json:"serialMax"`
+}
+
+func (p *CRLPartition) Validate(name string) error {
+	field := "crlPartitions." + name
+
+	if len(p.CRL) < 1 {
+		return &ValidationError{
+			Field:   field + ".crl",
+			Help:    "Each partition is published as its own certificate revocation list, and\nrequires one-or-more URLs where clients can download it.",
+			Message: "crl is required",
+		}
+	}
+
+	if len(p.Profiles) < 1 && p.SerialMin == "" && p.SerialMax == "" {
+		return &ValidationError{
+			Field:   field,
+			Help:    "A partition must select certificates by profiles, or a range of serial\nnumbers with serialMin and serialMax.",
+			Message: "profiles or serialMin and serialMax are required",
+		}
+	}
+
+	for _, serial := range []struct{ field, value string }{{"serialMin", p.SerialMin}, {"serialMax", p.SerialMax}} {
+		if serial.value == "" {
+			continue
+		}
+
+		if _, ok := parseSerial(serial.value); !ok {
+			return &ValidationError{
+				Field:   field + "." + serial.field,
+				Help:    "Serial numbers are hex-encoded, such as 7fffffffffffffffffffffffffffffff.",
+				Message: "invalid serial number",
+			}
+		}
+	}
+
+	return nil
+}
+
+// Contains reports whether a certificate with serial, signed with profile,
+// belongs to the partition.
+func (p *CRLPartition) Contains(serial *big.Int, profile string) bool {
+	for _, name := range p.Profiles {
+		if profile != "" && name == profile {
+			return true
+		}
+	}
+
+	if p.SerialMin == "" && p.SerialMax == "" {
+		return false
+	}
+
+	if lower, ok := parseSerial(p.SerialMin); ok && serial.Cmp(lower) < 0 {
+		return false
+	}
+
+	if upper, ok := parseSerial(p.SerialMax); ok && serial.Cmp(upper) > 0 {
+		return false
+	}
+
+	return true
+}
+
+func parseSerial(str string) (*big.Int, bool) {
+	return new(big.Int).SetString(strings.ReplaceAll(str, ":", ""), 16)
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"encoding/asn1"
+	"net/url"
+	"strconv"
+)
+
+// AnyPolicy is the ID of the anyPolicy certificate policy, defined by RFC 5280
+// Section 4.2.1.4.
+const AnyPolicy = "2.5.29.32.0"
+
+// Policy is a certificate policy under which certificates are signed, added
+// to their Certificate Policies extension.
+type Policy struct {
+	// ID is the dotted form of the OID identifying the policy, or any for
+	// anyPolicy.
+	ID string `json:"id"`
+
+	// CPS is an array of URLs pointing to where the Certification Practice
+	// Statement of the policy is published.
+	CPS []string `json:"cps"`
+
+	// UserNotice is a notice to be displayed to relying parties when the
+	// certificate is used.
+	UserNotice *UserNotice `json:"userNotice"`
+}
+
+func (p *Policy) Validate(field string) error {
+	if _, err := parseOID(p.policyID()); err != nil {
+		return &ValidationError{
+			Field:   field + ".id",
+			Help:    "A policy must be identified by the dotted form of its OID, such as\n1.3.6.1.4.1.99999.1, or any for anyPolicy.",
+			Message: err.Error(),
+		}
+	}
+
+	for _, cps := range p.CPS {
+		if u, err := url.Parse(cps); err != nil || u.Scheme == "" || u.Host == "" {
+			return &ValidationError{
+				Field:   field + ".cps",
+				Help:    "The Certification Practice Statement is referenced by URL, such as\nhttps://example.org/cps.",
+				Message: "invalid url " + strconv.Quote(cps),
+			}
+		}
+	}
+
+	if p.UserNotice != nil {
+		if err := p.UserNotice.Validate(field + ".userNotice"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OID returns the parsed ID of the policy.
+func (p *Policy) OID() asn1.ObjectIdentifier {
+	oid, _ := parseOID(p.policyID())
+	return oid
+}
+
+func (p *Policy) policyID() string {
+	if p.ID == "any" {
+		return AnyPolicy
+	}
+
+	return p.ID
+}
+
+// UserNotice is the user notice qualifier of a certificate policy, defined by
+// RFC 5280 Section 4.2.1.4.
+type UserNotice struct {
+	// Organization and NoticeNumbers reference notices published by an
+	// organization elsewhere. Both must be set, or neither.
+	Organization  string `json:"organization"`
+	NoticeNumbers []int  `json:"noticeNumbers"`
+
+	// ExplicitText is the text of the notice, up to 200 characters.
+	ExplicitText string `json:"explicitText"`
+}
+
+func (n *UserNotice) Validate(field string) error {
+	if (n.Organization == "") != (len(n.NoticeNumbers) == 0) {
+		return &ValidationError{
+			Field:   field,
+			Help:    "A notice reference requires both the organization and its noticeNumbers.",
+			Message: "organization and noticeNumbers must be set together",
+		}
+	}
+
+	if n.Organization == "" && n.ExplicitText == "" {
+		return &ValidationError{
+			Field:   field,
+			Message: "organization and noticeNumbers, or explicitText, are required",
+		}
+	}
+
+	if len([]rune(n.ExplicitText)) > 200 {
+		return &ValidationError{
+			Field:   field + ".explicitText",
+			Help:    "RFC 5280 limits the explicit text of a user notice to 200 characters.",
+			Message: "explicitText is too long",
+		}
+	}
+
+	return nil
+}
+
+// validateSkipCerts validates the number of certificates that may follow
+// before a policy constraint applies.
+func validateSkipCerts(field string, n *int) error {
+	if n != nil && *n < 0 {
+		return &ValidationError{
+			Field:   field,
+			Message: "must not be negative",
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,369 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultProfiles are the profiles available to every Certificate Authority,
+// unless overridden by a profile of the same name in its configuration.
+var DefaultProfiles = map[string]*Profile{
+	"server": {
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"serverAuth"},
+		Validity:    "8766h",
+	},
+	"client": {
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"clientAuth"},
+		Validity:    "8766h",
+	},
+	"ca": {
+		KeyUsage: []string{"digitalSignature", "keyCertSign", "cRLSign"},
+		Validity: "43830h",
+		CA:       true,
+	},
+	"ocsp": {
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"OCSPSigning"},
+		Validity:    "720h",
+		Extensions: []*Extension{
+			// id-pkix-ocsp-nocheck, RFC 6960 Section 4.2.2.2.1
+			{ID: "1.3.6.1.5.5.7.48.1.5", Value: "BQA="},
+		},
+	},
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"keyCertSign":       x509.KeyUsageCertSign,
+	"cRLSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// SAN types that may be listed in the AllowedSANs of a Profile.
+const (
+	SANTypeDNS   = "dns"
+	SANTypeIP    = "ip"
+	SANTypeEmail = "email"
+	SANTypeURI   = "uri"
+)
+
+// Profile configures the shape of the certificates signed by a Certificate
+// Authority for a particular purpose, such as web servers.
+type Profile struct {
+	// KeyUsage is the list of key usages set on the certificate, such as
+	// digitalSignature or keyEncipherment.
+	KeyUsage []string `json:"keyUsage"`
+
+	// ExtKeyUsage is the list of extended key usages set on the certificate,
+	// such as serverAuth or clientAuth, or the dotted form of an OID.
+	ExtKeyUsage []string `json:"extKeyUsage"`
+
+	// Validity is the maximum duration of time before a certificate signed
+	// with this profile will expire.
+	Validity string `json:"validity"`
+
+	// CA enables the certificate to be used as an intermediate Certificate
+	// Authority.
+	CA bool `json:"ca"`
+
+	// MaxPathLen is the maximum number of intermediate Certificate
+	// Authorities that may follow an intermediate signed with this profile.
+	// If omitted, the path length is unconstrained.
+	MaxPathLen *int `json:"maxPathLen"`
+
+	// NameConstraints restricts the names of the certificates that may be
+	// signed beneath an intermediate signed with this profile.
+	NameConstraints *NameConstraints `json:"nameConstraints"`
+
+	// CRL is an array of URLs pointing to where the Certificate Revocation
+	// List covering certificates signed with this profile can be accessed.
+	// If omitted, the crl of the Certificate Authority is used, and if
+	// empty, certificates have no CRL Distribution Points.
+	CRL []string `json:"crl"`
+
+	// Policies are the certificate policies of certificates signed with this
+	// profile. If omitted, the policies of the Certificate Authority are
+	// used.
+	Policies []*Policy `json:"policies"`
+
+	// RequireExplicitPolicy and InhibitPolicyMapping are the number of
+	// certificates that may follow an intermediate signed with this profile
+	// before an acceptable policy is required, or policy mapping is no longer
+	// permitted. If omitted, they are unconstrained.
+	RequireExplicitPolicy *int `json:"requireExplicitPolicy"`
+	InhibitPolicyMapping  *int `json:"inhibitPolicyMapping"`
+
+	// InhibitAnyPolicy is the number of certificates that may follow an
+	// intermediate signed with this profile before anyPolicy is no longer
+	// accepted. If omitted, anyPolicy is accepted.
+	InhibitAnyPolicy *int `json:"inhibitAnyPolicy"`
+
+	// AllowedSANs restricts the types of Subject Alternative Name that may be
+	// requested, one of dns, ip, email or uri. If omitted, all types are
+	// allowed.
+	AllowedSANs []string `json:"allowedSans"`
+
+	// Extensions are additional extensions added verbatim to the
+	// certificate.
+	Extensions []*Extension `json:"extensions"`
+}
+
+func (p *Profile) Validate(name string) error {
+	field := "profiles." + name
+
+	for _, ku := range p.KeyUsage {
+		if _, ok := keyUsages[ku]; !ok {
+			return &ValidationError{
+				Field:   field + ".keyUsage",
+				Help:    "Valid key usages include digitalSignature, contentCommitment, keyEncipherment,\ndataEncipherment, keyAgreement, keyCertSign, cRLSign, encipherOnly and\ndecipherOnly.",
+				Message: "unknown key usage " + strconv.Quote(ku),
+			}
+		}
+	}
+
+	for _, eku := range p.ExtKeyUsage {
+		if _, ok := extKeyUsages[eku]; !ok {
+			if _, err := parseOID(eku); err != nil {
+				return &ValidationError{
+					Field:   field + ".extKeyUsage",
+					Help:    "Valid extended key usages include any, serverAuth, clientAuth, codeSigning,\nemailProtection, timeStamping and OCSPSigning, or an OID such as 1.2.3.4.",
+					Message: "unknown extended key usage " + strconv.Quote(eku),
+				}
+			}
+		}
+	}
+
+	if p.Validity == "" {
+		return &ValidationError{
+			Field:   field + ".validity",
+			Help:    "All certificates must have an expiry, this can be express is ns, ms, s, m or h.",
+			Message: "validity is required",
+		}
+	} else if _, err := time.ParseDuration(p.Validity); err != nil {
+		return &ValidationError{
+			Field:   field + ".validity",
+			Help:    "All certificates must have an expiry, this can be express is ns, ms, s, m or h.",
+			Message: err.Error(),
+		}
+	}
+
+	if p.MaxPathLen != nil {
+		if !p.CA {
+			return &ValidationError{
+				Field:   field + ".maxPathLen",
+				Help:    "A path length only applies to certificate authorities, set ca to true.",
+				Message: "maxPathLen requires ca",
+			}
+		}
+
+		if *p.MaxPathLen < 0 {
+			return &ValidationError{
+				Field:   field + ".maxPathLen",
+				Message: "maxPathLen must not be negative",
+			}
+		}
+	}
+
+	if p.NameConstraints != nil {
+		if !p.CA {
+			return &ValidationError{
+				Field:   field + ".nameConstraints",
+				Help:    "Name constraints only apply to certificate authorities, set ca to true.",
+				Message: "nameConstraints requires ca",
+			}
+		}
+
+		if err := p.NameConstraints.Validate(field + ".nameConstraints"); err != nil {
+			return err
+		}
+	}
+
+	for i, policy := range p.Policies {
+		if policy == nil {
+			return &ValidationError{
+				Field:   field + ".policies." + strconv.Itoa(i),
+				Message: "policy must not be null",
+			}
+		}
+
+		if err := policy.Validate(field + ".policies." + strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+
+	for _, skip := range []struct {
+		field string
+		value *int
+	}{
+		{"requireExplicitPolicy", p.RequireExplicitPolicy},
+		{"inhibitPolicyMapping", p.InhibitPolicyMapping},
+		{"inhibitAnyPolicy", p.InhibitAnyPolicy},
+	} {
+		if skip.value == nil {
+			continue
+		}
+
+		if !p.CA {
+			return &ValidationError{
+				Field:   field + "." + skip.field,
+				Help:    "Policy constraints only apply to certificate authorities, set ca to true.",
+				Message: skip.field + " requires ca",
+			}
+		}
+
+		if err := validateSkipCerts(field+"."+skip.field, skip.value); err != nil {
+			return err
+		}
+	}
+
+	for _, san := range p.AllowedSANs {
+		switch san {
+		case SANTypeDNS, SANTypeIP, SANTypeEmail, SANTypeURI:
+
+		default:
+			return &ValidationError{
+				Field:   field + ".allowedSans",
+				Help:    "Valid Subject Alternative Name types include dns, ip, email and uri.",
+				Message: "unknown SAN type " + strconv.Quote(san),
+			}
+		}
+	}
+
+	for _, ext := range p.Extensions {
+		if err := ext.Validate(field + ".extensions"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// KeyUsages returns the combined key usages of the profile.
+func (p *Profile) KeyUsages() x509.KeyUsage {
+	var usage x509.KeyUsage
+	for _, ku := range p.KeyUsage {
+		usage |= keyUsages[ku]
+	}
+
+	return usage
+}
+
+// ExtKeyUsages returns the known extended key usages of the profile, and the
+// OIDs of any extended key usages unknown to Go.
+func (p *Profile) ExtKeyUsages() ([]x509.ExtKeyUsage, []asn1.ObjectIdentifier) {
+	var (
+		known   []x509.ExtKeyUsage
+		unknown []asn1.ObjectIdentifier
+	)
+
+	for _, eku := range p.ExtKeyUsage {
+		if usage, ok := extKeyUsages[eku]; ok {
+			known = append(known, usage)
+		} else if oid, err := parseOID(eku); err == nil {
+			unknown = append(unknown, oid)
+		}
+	}
+
+	return known, unknown
+}
+
+// AllowsSAN reports whether certificates signed with the profile may contain
+// Subject Alternative Names of sanType.
+func (p *Profile) AllowsSAN(sanType string) bool {
+	if p.AllowedSANs == nil {
+		return true
+	}
+
+	for _, san := range p.AllowedSANs {
+		if san == sanType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Extension is an X.509 certificate extension.
+type Extension struct {
+	// ID is the dotted form of the OID identifying the extension.
+	ID string `json:"id"`
+
+	// Critical marks the extension as one that must be understood by clients.
+	Critical bool `json:"critical"`
+
+	// Value is the base64 encoded DER value of the extension.
+	Value string `json:"value"`
+}
+
+func (e *Extension) Validate(field string) error {
+	if _, err := parseOID(e.ID); err != nil {
+		return &ValidationError{
+			Field:   field + ".id",
+			Help:    "An extension must be identified by the dotted form of its OID, such as 1.2.3.4.",
+			Message: err.Error(),
+		}
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(e.Value); err != nil {
+		return &ValidationError{
+			Field:   field + ".value",
+			Help:    "The value of an extension must be its base64 encoded DER value.",
+			Message: err.Error(),
+		}
+	}
+
+	return nil
+}
+
+// OID returns the parsed ID of the extension.
+func (e *Extension) OID() asn1.ObjectIdentifier {
+	oid, _ := parseOID(e.ID)
+	return oid
+}
+
+// Bytes returns the decoded value of the extension.
+func (e *Extension) Bytes() []byte {
+	value, _ := base64.StdEncoding.DecodeString(e.Value)
+	return value
+}
+
+// parseOID parses the dotted form of an OID, such as 1.2.3.4.
+func parseOID(str string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(str, ".")
+	if len(parts) < 2 {
+		return nil, &strconv.NumError{Func: "parseOID", Num: str, Err: strconv.ErrSyntax}
+	}
+
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, &strconv.NumError{Func: "parseOID", Num: str, Err: strconv.ErrSyntax}
+		}
+
+		oid[i] = n
+	}
+
+	return oid, nil
+}
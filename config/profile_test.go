@@ -0,0 +1,136 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestProfileValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		field   string
+		message string
+	}{
+		{
+			name:    "Valid",
+			profile: `{"keyUsage": ["digitalSignature"], "extKeyUsage": ["serverAuth", "1.2.3.4"], "validity": "24h", "allowedSans": ["dns"]}`,
+		},
+		{
+			name:    "UnknownKeyUsage",
+			profile: `{"keyUsage": ["digitalSignature", "signEverything"], "validity": "24h"}`,
+			field:   "profiles.test.keyUsage",
+			message: `unknown key usage "signEverything"`,
+		},
+		{
+			name:    "UnknownExtKeyUsage",
+			profile: `{"extKeyUsage": ["webAuth"], "validity": "24h"}`,
+			field:   "profiles.test.extKeyUsage",
+			message: `unknown extended key usage "webAuth"`,
+		},
+		{
+			name:    "MissingValidity",
+			profile: `{"keyUsage": ["digitalSignature"]}`,
+			field:   "profiles.test.validity",
+			message: "validity is required",
+		},
+		{
+			name:    "MaxPathLenWithoutCA",
+			profile: `{"validity": "24h", "maxPathLen": 0}`,
+			field:   "profiles.test.maxPathLen",
+			message: "maxPathLen requires ca",
+		},
+		{
+			name:    "UnknownSANType",
+			profile: `{"validity": "24h", "allowedSans": ["upn"]}`,
+			field:   "profiles.test.allowedSans",
+			message: `unknown SAN type "upn"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var profile Profile
+			err := json.Unmarshal([]byte(test.profile), &profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = profile.Validate("test")
+			if test.field == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				t.Fatalf("expected validation error, got %v", err)
+			}
+
+			if ve.Field != test.field || ve.Message != test.message {
+				t.Errorf("expected %s: %s, got %s: %s", test.field, test.message, ve.Field, ve.Message)
+			}
+		})
+	}
+}
+
+func TestProfileUsages(t *testing.T) {
+	profile := &Profile{
+		KeyUsage:    []string{"digitalSignature", "keyEncipherment"},
+		ExtKeyUsage: []string{"serverAuth", "clientAuth", "1.2.3.4"},
+	}
+
+	if ku := profile.KeyUsages(); ku != x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment {
+		t.Errorf("unexpected key usage %d", ku)
+	}
+
+	known, unknown := profile.ExtKeyUsages()
+
+	if expected := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}; !reflect.DeepEqual(known, expected) {
+		t.Errorf("expected extended key usages %v, got %v", expected, known)
+	}
+
+	if expected := []asn1.ObjectIdentifier{{1, 2, 3, 4}}; !reflect.DeepEqual(unknown, expected) {
+		t.Errorf("expected unknown extended key usages %v, got %v", expected, unknown)
+	}
+}
+
+func TestCAProfile(t *testing.T) {
+	override := &Profile{Validity: "1h"}
+
+	ca := &CA{
+		Profiles: map[string]*Profile{
+			"server": override,
+			"device": {Validity: "24h"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expected *Profile
+	}{
+		{"server", override},
+		{"device", ca.Profiles["device"]},
+		{"client", DefaultProfiles["client"]},
+		{"unknown", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profile, ok := ca.Profile(test.name)
+			if ok != (test.expected != nil) {
+				t.Fatalf("expected found %v, got %v", test.expected != nil, ok)
+			}
+
+			if profile != test.expected {
+				t.Errorf("expected profile %+v, got %+v", test.expected, profile)
+			}
+		})
+	}
+}
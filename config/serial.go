@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// Serial schemes assign the serial numbers of signed certificates.
+const (
+	// SerialRandom serials are 128 random bits, following the prefix if one
+	// is configured.
+	SerialRandom = "random"
+
+	// SerialCounter serials are a counter stored in the issuance database,
+	// incremented for every certificate and following the prefix if one is
+	// configured.
+	SerialCounter = "counter"
+)
+
+// MaxSerialPrefix is the maximum length in bytes of a serial prefix, keeping
+// serials within the 20 octets allowed by RFC 5280 Section 4.1.2.2.
+const MaxSerialPrefix = 3
+
+// Serial configures how serial numbers are assigned to the certificates signed
+// by a Certificate Authority.
+type Serial struct {
+	// Scheme is either random or counter. If empty, random is used.
+	Scheme string `json:"scheme"`
+
+	// Prefix is hex-encoded bytes that begin every serial, such as to
+	// distinguish the certificates of multiple Certificate Authorities.
+	Prefix string `json:"prefix"`
+}
+
+func (s *Serial) Validate() error {
+	switch s.Scheme {
+	case "", SerialRandom, SerialCounter:
+
+	default:
+		return &ValidationError{
+			Field:   "serial.scheme",
+			Help:    "Serials are either random, or a counter stored in the issuance database.\nPublicly trusted certificates require at least 64 random bits, so counter\nshould only be used for private certificate authorities.",
+			Message: "unknown scheme",
+		}
+	}
+
+	if s.Prefix != "" {
+		prefix, err := s.PrefixBytes()
+		if err != nil || len(prefix) < 1 || len(prefix) > MaxSerialPrefix || prefix[0] == 0 {
+			return &ValidationError{
+				Field:   "serial.prefix",
+				Help:    "The prefix is one to three hex-encoded bytes, such as 0a:01, and must\nnot begin with 00.",
+				Message: "invalid serial prefix",
+			}
+		}
+	}
+
+	return nil
+}
+
+// PrefixBytes returns the decoded prefix of serials.
+func (s *Serial) PrefixBytes() ([]byte, error) {
+	return hex.DecodeString(strings.ReplaceAll(s.Prefix, ":", ""))
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSerialValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		serial Serial
+		prefix []byte
+		field  string
+	}{
+		{"Default", Serial{}, []byte{}, ""},
+		{"Random", Serial{Scheme: SerialRandom, Prefix: "0a"}, []byte{0x0a}, ""},
+		{"Counter", Serial{Scheme: SerialCounter, Prefix: "0a:01:ff"}, []byte{0x0a, 0x01, 0xff}, ""},
+		{"UnknownScheme", Serial{Scheme: "sequential"}, nil, "serial.scheme"},
+		{"LongPrefix", Serial{Prefix: "0a:01:02:03"}, nil, "serial.prefix"},
+		{"ZeroPrefix", Serial{Prefix: "00:01"}, nil, "serial.prefix"},
+		{"InvalidPrefix", Serial{Prefix: "xyz"}, nil, "serial.prefix"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.serial.Validate()
+			if test.field != "" {
+				ve, ok := err.(*ValidationError)
+				if !ok || ve.Field != test.field {
+					t.Fatalf("expected validation error of %s, got %v", test.field, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			prefix, err := test.serial.PrefixBytes()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			} else if !bytes.Equal(prefix, test.prefix) {
+				t.Errorf("expected prefix %x, got %x", test.prefix, prefix)
+			}
+		})
+	}
+}
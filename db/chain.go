@@ -0,0 +1,310 @@
+package db
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Verifier is implemented by databases that can prove their records have not
+// been tampered with.
+type Verifier interface {
+	// Checkpoint signs the current state of the database with signer, the
+	// private key of the Certificate Authority.
+	Checkpoint(ctx context.Context, signer crypto.Signer) (*Checkpoint, error)
+
+	// Uncheckpointed returns the number of entries appended since the last
+	// checkpoint.
+	Uncheckpointed(ctx context.Context) (int, error)
+
+	// Verify checks the integrity of the database and the signatures of its
+	// checkpoints against the certificate of the Certificate Authority.
+	Verify(ctx context.Context, ca *x509.Certificate) (*Verification, error)
+}
+
+// Checkpoint is a signature by the Certificate Authority over every entry in
+// the database that came before it.
+type Checkpoint struct {
+	// Entries is the number of entries before the checkpoint.
+	Entries int `json:"entries"`
+
+	// Hash is the hash of the entry immediately before the checkpoint.
+	Hash string `json:"hash"`
+
+	// Time is when the checkpoint was signed.
+	Time time.Time `json:"time"`
+
+	// Algorithm is the x509.SignatureAlgorithm used to sign the checkpoint.
+	Algorithm string `json:"algorithm"`
+
+	// Signature is the signature of the Certificate Authority over the
+	// entries, hash and time.
+	Signature []byte `json:"signature"`
+}
+
+// Verification summarises the result of verifying a database.
+type Verification struct {
+	// Entries is the number of entries in the database.
+	Entries int
+
+	// Checkpoints is the number of checkpoints verified.
+	Checkpoints int
+
+	// Unchained is the number of entries written before chaining was
+	// introduced, which cannot be verified.
+	Unchained int
+
+	// Uncheckpointed is the number of entries after the last checkpoint,
+	// which are chained but not signed.
+	Uncheckpointed int
+}
+
+// jsonEntry is a line of a JSON database, either a record or a checkpoint.
+type jsonEntry struct {
+	Prev       string      `json:"prev,omitempty"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	*Record
+}
+
+func (j *JSON) Checkpoint(ctx context.Context, signer crypto.Signer) (cp *Checkpoint, err error) {
+	err = j.withLock(true, func(file *os.File) error {
+		hash, err := repairTail(file)
+		if err != nil {
+			return fmt.Errorf("could not read last entry: %w", err)
+		}
+
+		var entries int
+		err = j.entries(func(line int, raw []byte, entry *jsonEntry) error {
+			entries = line
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		cp = &Checkpoint{
+			Entries: entries,
+			Hash:    hash,
+			Time:    time.Now().UTC(),
+		}
+
+		err = cp.sign(signer)
+		if err != nil {
+			return err
+		}
+
+		err = writeEntry(file, hash, &jsonEntry{Checkpoint: cp})
+		if err != nil {
+			return err
+		}
+
+		// the latest checkpoint is also kept outside of the database, so
+		// removing entries from the end of the database can be detected.
+		data, err := json.Marshal(cp)
+		if err != nil {
+			return err
+		}
+
+		return writeFileSync(j.checkpointPath(), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cp, nil
+}
+
+func (j *JSON) Uncheckpointed(ctx context.Context) (n int, err error) {
+	err = j.withLock(false, func(file *os.File) error {
+		return j.entries(func(line int, raw []byte, entry *jsonEntry) error {
+			if entry.Checkpoint != nil {
+				n = 0
+			} else {
+				n++
+			}
+
+			return nil
+		})
+	})
+
+	return
+}
+
+func (j *JSON) Verify(ctx context.Context, ca *x509.Certificate) (v *Verification, err error) {
+	err = j.withLock(false, func(file *os.File) error {
+		v, err = j.verify(ca)
+		return err
+	})
+
+	return
+}
+
+func (j *JSON) verify(ca *x509.Certificate) (*Verification, error) {
+	var (
+		v           Verification
+		prev        string
+		chained     bool
+		checkpoints = make(map[int]*Checkpoint)
+	)
+
+	err := j.entries(func(line int, raw []byte, entry *jsonEntry) error {
+		switch {
+		case entry.Prev == "" && !chained && entry.Checkpoint == nil:
+			// entries written before chaining was introduced, or the first
+			// entry of the database, have no previous hash. only the last of
+			// them is covered by the hash of the entry after it.
+			v.Unchained = line - 1
+
+		case entry.Prev != prev:
+			return fmt.Errorf("%s:%d: previous hash does not match line %d, entries have been edited, removed or reordered", j.path, line, line-1)
+
+		default:
+			chained = true
+		}
+
+		if entry.Checkpoint != nil {
+			cp := entry.Checkpoint
+
+			if cp.Entries != line-1 || cp.Hash != prev {
+				return fmt.Errorf("%s:%d: checkpoint does not match its position, entries have been removed or reordered", j.path, line)
+			}
+
+			err := cp.verify(ca)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", j.path, line, err)
+			}
+
+			checkpoints[cp.Entries] = cp
+			chained = true
+			v.Checkpoints++
+			v.Uncheckpointed = 0
+		} else {
+			v.Uncheckpointed++
+		}
+
+		prev = entryHash(raw)
+		v.Entries = line
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !chained {
+		v.Unchained = v.Entries
+	}
+
+	latest, err := j.latestCheckpoint()
+	if err != nil {
+		return nil, fmt.Errorf("could not read latest checkpoint: %w", err)
+	} else if latest != nil {
+		err = latest.verify(ca)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", j.checkpointPath(), err)
+		}
+
+		cp, ok := checkpoints[latest.Entries]
+		if !ok {
+			return nil, fmt.Errorf("latest checkpoint is at line %d but the database has %d lines, it has been truncated", latest.Entries+1, v.Entries)
+		} else if string(cp.Signature) != string(latest.Signature) {
+			return nil, fmt.Errorf("latest checkpoint does not match the database at line %d, entries have been edited, removed or reordered", latest.Entries+1)
+		}
+	}
+
+	return &v, nil
+}
+
+func (j *JSON) checkpointPath() string {
+	return j.path + ".checkpoint.json"
+}
+
+func (j *JSON) latestCheckpoint() (*Checkpoint, error) {
+	data, err := os.ReadFile(j.checkpointPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	cp := new(Checkpoint)
+
+	err = json.Unmarshal(data, cp)
+	if err != nil {
+		return nil, err
+	}
+
+	return cp, nil
+}
+
+// signed returns the bytes of the checkpoint covered by its signature.
+func (cp *Checkpoint) signed() []byte {
+	return []byte(fmt.Sprintf("yubca checkpoint\nentries: %d\nhash: %s\ntime: %s\n", cp.Entries, cp.Hash, cp.Time.UTC().Format(time.RFC3339Nano)))
+}
+
+func (cp *Checkpoint) sign(signer crypto.Signer) error {
+	var (
+		algo   x509.SignatureAlgorithm
+		digest []byte
+		opts   crypto.SignerOpts
+	)
+
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		algo, digest, opts = x509.PureEd25519, cp.signed(), crypto.Hash(0)
+
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(cp.signed())
+		algo, digest, opts = x509.SHA256WithRSA, sum[:], crypto.SHA256
+
+	default:
+		sum := sha256.Sum256(cp.signed())
+		algo, digest, opts = x509.ECDSAWithSHA256, sum[:], crypto.SHA256
+	}
+
+	sig, err := signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return fmt.Errorf("could not sign checkpoint: %w", err)
+	}
+
+	cp.Algorithm = algo.String()
+	cp.Signature = sig
+
+	return nil
+}
+
+func (cp *Checkpoint) verify(ca *x509.Certificate) error {
+	var algo x509.SignatureAlgorithm
+	for _, a := range []x509.SignatureAlgorithm{x509.ECDSAWithSHA256, x509.SHA256WithRSA, x509.PureEd25519} {
+		if a.String() == cp.Algorithm {
+			algo = a
+		}
+	}
+
+	if algo == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("checkpoint signed with unknown algorithm %q", cp.Algorithm)
+	}
+
+	err := ca.CheckSignature(algo, cp.signed(), cp.Signature)
+	if err != nil {
+		return fmt.Errorf("checkpoint signature is invalid: %w", err)
+	}
+
+	return nil
+}
+
+// entryHash returns the hash of the raw encoding of an entry, which is
+// recorded by the entry after it.
+func entryHash(raw []byte) string {
+	return sha256fingerprint(raw)
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONVerify(t *testing.T) {
+	ca, signer := testCA(t)
+
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		err    string
+	}{
+		{
+			name:   "Untampered",
+			tamper: func(lines [][]byte) [][]byte { return lines },
+		},
+		{
+			name: "Edited",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(`"profile":"server"`), []byte(`"profile":"client"`), 1)
+				return lines
+			},
+			err: "entries have been edited, removed or reordered",
+		},
+		{
+			name: "Removed",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[2:]...)
+			},
+			err: "entries have been edited, removed or reordered",
+		},
+		{
+			name: "Reordered",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			err: "entries have been edited, removed or reordered",
+		},
+		{
+			name: "Truncated",
+			tamper: func(lines [][]byte) [][]byte {
+				return lines[:3]
+			},
+			err: "it has been truncated",
+		},
+		{
+			name: "ForgedCheckpoint",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[3] = bytes.Replace(lines[3], []byte(`"entries":3`), []byte(`"entries":2`), 1)
+				return lines
+			},
+			err: "checkpoint does not match its position",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			j := testChain(t, signer)
+
+			data, err := os.ReadFile(j.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+			lines = test.tamper(lines)
+
+			err = os.WriteFile(j.path, append(bytes.Join(lines, []byte("\n")), '\n'), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v, err := j.Verify(context.Background(), ca)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			expected := Verification{Entries: 5, Checkpoints: 1, Uncheckpointed: 1}
+			if *v != expected {
+				t.Errorf("expected %+v, got %+v", expected, *v)
+			}
+		})
+	}
+}
+
+func TestJSONVerifyWrongCA(t *testing.T) {
+	_, signer := testCA(t)
+	other, _ := testCA(t)
+
+	j := testChain(t, signer)
+
+	_, err := j.Verify(context.Background(), other)
+	if err == nil || !strings.Contains(err.Error(), "checkpoint signature is invalid") {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+}
+
+// testChain returns a JSON database of three records, a checkpoint, and a
+// further record.
+func testChain(t *testing.T, signer crypto.Signer) *JSON {
+	t.Helper()
+
+	ctx := context.Background()
+
+	j, err := NewJSON(filepath.Join(t.TempDir(), "issued.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 4; i++ {
+		err = j.Reserve(ctx, big.NewInt(i), &Issuance{Profile: "server"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 3 {
+			_, err = j.Checkpoint(ctx, signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return j
+}
+
+func testCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
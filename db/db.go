@@ -2,10 +2,426 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
 )
 
+// ErrNotFound is returned when a certificate is not in the database.
+var ErrNotFound = errors.New("certificate not found")
+
+// ErrExists is returned when a serial has already been reserved or recorded in
+// the database.
+var ErrExists = errors.New("serial already exists")
+
+// ErrAbandoned is returned when committing a certificate whose reservation was
+// abandoned, the certificate must not be released.
+var ErrAbandoned = errors.New("reservation was abandoned")
+
 // DB is an index of the certificates signed by a Certificate Authority.
 type DB interface {
-	AppendCertificate(ctx context.Context, cert *x509.Certificate) error
+	// Reserve records serial as pending before a certificate is signed with
+	// it, returning ErrExists if it is already in the database.
+	Reserve(ctx context.Context, serial *big.Int, issuance *Issuance) error
+
+	// AppendCertificate records cert as signed by the Certificate Authority,
+	// committing the reservation of its serial if there is one. It returns
+	// ErrExists if a certificate has already been recorded with its serial,
+	// or ErrAbandoned if its reservation was abandoned.
+	AppendCertificate(ctx context.Context, cert *x509.Certificate, issuance *Issuance) error
+
+	// Abandon marks the pending reservation of serial as abandoned, after the
+	// signing it was reserved for was interrupted.
+	Abandon(ctx context.Context, serial *big.Int) error
+
+	// Certificate returns the record of the certificate with serial, or
+	// ErrNotFound if it has not been recorded.
+	Certificate(ctx context.Context, serial *big.Int) (*Record, error)
+
+	// RevokeCertificate records the certificate with serial as revoked. If the
+	// certificate has not been recorded, a record containing only its serial
+	// and revocation is added. A nil revocation releases a certificate on
+	// hold.
+	RevokeCertificate(ctx context.Context, serial *big.Int, revocation *Revocation) error
+
+	// Certificates returns the records of every certificate in the database.
+	Certificates(ctx context.Context) ([]*Record, error)
+
+	// Search returns the records of the certificates in the database matching
+	// query, in the order they were recorded.
+	Search(ctx context.Context, query *Query) ([]*Record, error)
+
+	// Increment increases the counter called name by one and returns its new
+	// value, starting from one.
+	Increment(ctx context.Context, name string) (*big.Int, error)
+
+	// Counters returns the current value of every counter in the database.
+	Counters(ctx context.Context) (map[string]*big.Int, error)
+
+	// Close releases any resources held by the database.
+	Close() error
+}
+
+// Importer is implemented by databases that records can be migrated into from
+// another database.
+type Importer interface {
+	// Import adds records and counters to the database, none of which may
+	// already exist in it.
+	Import(ctx context.Context, records []*Record, counters map[string]*big.Int) error
+}
+
+// RecordVersion is the schema version of the records written by this version
+// of yubca. Records written before versioning was introduced are version 1.
+const RecordVersion = 2
+
+// Record is an individual certificate that has been signed by the
+// Certificate Authority.
+type Record struct {
+	Version        int          `json:"version,omitempty"`
+	Serial         string       `json:"serial"`
+	Fingerprint    string       `json:"fingerprint"`
+	CommonName     string       `json:"commonName"`
+	NotBefore      time.Time    `json:"notBefore"`
+	NotAfter       time.Time    `json:"notAfter"`
+	DNSNames       []string     `json:"dnsNames,omitempty"`
+	IPAddresses    []string     `json:"ipAddresses,omitempty"`
+	EmailAddresses []string     `json:"emailAddresses,omitempty"`
+	URIs           []string     `json:"uris,omitempty"`
+	KeyUsage       []string     `json:"keyUsage,omitempty"`
+	ExtKeyUsage    []string     `json:"extKeyUsage,omitempty"`
+	IssuerKeyID    string       `json:"issuerKeyId,omitempty"`
+	Profile        string       `json:"profile,omitempty"`
+	Requester      string       `json:"requester,omitempty"`
+	Operator       string       `json:"operator,omitempty"`
+	CSRFingerprint string       `json:"csrFingerprint,omitempty"`
+	Certificate    []byte       `json:"certificate,omitempty"`
+	Reservation    *Reservation `json:"reservation,omitempty"`
+	Revocation     *Revocation  `json:"revocation,omitempty"`
+}
+
+// NewReservation returns the pending record of serial, reserved to sign a
+// certificate as described by issuance.
+func NewReservation(serial *big.Int, issuance *Issuance) *Record {
+	record := &Record{
+		Version:     RecordVersion,
+		Serial:      SerialString(serial),
+		Reservation: &Reservation{Time: time.Now().UTC()},
+	}
+
+	record.setIssuance(issuance)
+
+	return record
+}
+
+// NewRecord returns the record of cert signed as described by issuance.
+func NewRecord(cert *x509.Certificate, issuance *Issuance) *Record {
+	record := &Record{
+		Version:        RecordVersion,
+		Serial:         SerialString(cert.SerialNumber),
+		Fingerprint:    sha256fingerprint(cert.Raw),
+		CommonName:     cert.Subject.CommonName,
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		KeyUsage:       keyUsageNames(cert.KeyUsage),
+		ExtKeyUsage:    extKeyUsageNames(cert),
+		IssuerKeyID:    hex.EncodeToString(cert.AuthorityKeyId),
+		Certificate:    cert.Raw,
+	}
+
+	for _, ip := range cert.IPAddresses {
+		record.IPAddresses = append(record.IPAddresses, ip.String())
+	}
+
+	for _, uri := range cert.URIs {
+		record.URIs = append(record.URIs, uri.String())
+	}
+
+	record.setIssuance(issuance)
+
+	return record
+}
+
+func (r *Record) setIssuance(issuance *Issuance) {
+	if issuance == nil {
+		return
+	}
+
+	r.Profile = issuance.Profile
+	r.Requester = issuance.Requester
+	r.Operator = issuance.Operator
+
+	if issuance.CSR != nil {
+		r.CSRFingerprint = sha256fingerprint(issuance.CSR.Raw)
+	}
+}
+
+// commit returns an error if cert cannot be recorded over the existing
+// record of its serial.
+func (r *Record) commit() error {
+	switch {
+	case r.Reservation == nil:
+		return ErrExists
+
+	case r.Reservation.Abandoned:
+		return ErrAbandoned
+
+	default:
+		return nil
+	}
+}
+
+// upgrade brings a record read from the database up to the current schema,
+// records from before versioning was introduced have no version.
+func (r *Record) upgrade() {
+	if r.Version == 0 {
+		r.Version = 1
+	}
+}
+
+// Status of a certificate returned by Record.Status.
+const (
+	StatusValid     = "valid"
+	StatusExpired   = "expired"
+	StatusRevoked   = "revoked"
+	StatusPending   = "pending"
+	StatusAbandoned = "abandoned"
+)
+
+// Status returns whether the certificate is valid, expired or revoked at now,
+// or whether its serial is pending or abandoned without being signed.
+func (r *Record) Status(now time.Time) string {
+	switch {
+	case r.Reservation != nil && r.Reservation.Abandoned:
+		return StatusAbandoned
+
+	case r.Reservation != nil:
+		return StatusPending
+
+	case r.Revocation != nil:
+		return StatusRevoked
+
+	case !r.NotAfter.IsZero() && now.After(r.NotAfter):
+		return StatusExpired
+
+	default:
+		return StatusValid
+	}
+}
+
+// Query filters the records returned by Search. Empty fields match every
+// record.
+type Query struct {
+	// Text matches records whose serial begins with it, or whose common name
+	// or any DNS name contains it, ignoring case.
+	Text string
+
+	// Serial matches records whose hex-encoded serial begins with it.
+	Serial string
+
+	// CommonName matches records whose common name contains it, ignoring
+	// case.
+	CommonName string
+
+	// DNSName matches records with any DNS name containing it, ignoring case.
+	DNSName string
+
+	// ExpiresAfter and ExpiresBefore match records expiring within the window
+	// between them.
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+
+	// Status matches records with the status StatusValid, StatusExpired,
+	// StatusRevoked, StatusPending or StatusAbandoned at now.
+	Status string
+}
+
+// Match returns true if record matches every field of the query, with its
+// status determined at now.
+func (q *Query) Match(record *Record, now time.Time) bool {
+	if q.Text != "" {
+		if !hasSerialPrefix(record.Serial, q.Text) && !containsFold(record.CommonName, q.Text) && !anyContainsFold(record.DNSNames, q.Text) {
+			return false
+		}
+	}
+
+	if q.Serial != "" && !hasSerialPrefix(record.Serial, q.Serial) {
+		return false
+	}
+
+	if q.CommonName != "" && !containsFold(record.CommonName, q.CommonName) {
+		return false
+	}
+
+	if q.DNSName != "" && !anyContainsFold(record.DNSNames, q.DNSName) {
+		return false
+	}
+
+	// records of certificates revoked without ever being recorded have no
+	// expiry to match.
+	if (!q.ExpiresAfter.IsZero() || !q.ExpiresBefore.IsZero()) && record.NotAfter.IsZero() {
+		return false
+	}
+
+	if !q.ExpiresAfter.IsZero() && !record.NotAfter.After(q.ExpiresAfter) {
+		return false
+	}
+
+	if !q.ExpiresBefore.IsZero() && !record.NotAfter.Before(q.ExpiresBefore) {
+		return false
+	}
+
+	if q.Status != "" && record.Status(now) != q.Status {
+		return false
+	}
+
+	return true
+}
+
+// hasSerialPrefix returns true if serial begins with prefix, which may be
+// uppercase or separated by colons.
+func hasSerialPrefix(serial, prefix string) bool {
+	return strings.HasPrefix(serial, normalizeSerialPrefix(prefix))
+}
+
+func normalizeSerialPrefix(prefix string) string {
+	return strings.ToLower(strings.ReplaceAll(prefix, ":", ""))
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func anyContainsFold(ss []string, substr string) bool {
+	for _, s := range ss {
+		if containsFold(s, substr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Issuance describes how a certificate came to be signed.
+type Issuance struct {
+	// Profile is the name of the profile the certificate was signed with.
+	Profile string
+
+	// Requester identifies who requested the certificate.
+	Requester string
+
+	// Operator identifies who signed the certificate.
+	Operator string
+
+	// CSR is the certificate signing request the certificate was signed
+	// from, if any.
+	CSR *x509.CertificateRequest
+}
+
+// Reservation records when a serial was reserved to sign a certificate with,
+// and whether the signing was abandoned.
+type Reservation struct {
+	// Time is when the serial was reserved.
+	Time time.Time `json:"time"`
+
+	// Abandoned is set once the signing is known to have been interrupted,
+	// and the serial will never be used.
+	Abandoned bool `json:"abandoned,omitempty"`
+}
+
+// Revocation records when and why a certificate was revoked.
+type Revocation struct {
+	// Time is when the certificate was revoked.
+	Time time.Time `json:"time"`
+
+	// Reason is the CRLReason code defined by RFC 5280 Section 5.3.1.
+	Reason int `json:"reason"`
+}
+
+// SerialString returns the canonical form of serial used to identify records,
+// the hex encoding of its big-endian bytes.
+func SerialString(serial *big.Int) string {
+	return hex.EncodeToString(serial.Bytes())
+}
+
+func sha256fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return "SHA256:" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+var keyUsages = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+var oidExtensionExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+var extKeyUsages = map[string]string{
+	"2.5.29.37.0":       "any",
+	"1.3.6.1.5.5.7.3.1": "serverAuth",
+	"1.3.6.1.5.5.7.3.2": "clientAuth",
+	"1.3.6.1.5.5.7.3.3": "codeSigning",
+	"1.3.6.1.5.5.7.3.4": "emailProtection",
+	"1.3.6.1.5.5.7.3.8": "timeStamping",
+	"1.3.6.1.5.5.7.3.9": "OCSPSigning",
+}
+
+// keyUsageNames returns the names of the key usages in ku, as used in the
+// configuration of profiles.
+func keyUsageNames(ku x509.KeyUsage) []string {
+	var names []string
+	for _, u := range keyUsages {
+		if ku&u.usage != 0 {
+			names = append(names, u.name)
+		}
+	}
+
+	return names
+}
+
+// extKeyUsageNames returns the names of the extended key usages of cert, as
+// used in the configuration of profiles, or the dotted form of their OID. They
+// are read from its extension, as x509 does not expose the OID of every usage
+// it recognizes.
+func extKeyUsageNames(cert *x509.Certificate) []string {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtensionExtKeyUsage) {
+			continue
+		}
+
+		var oids []asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(ext.Value, &oids); err != nil {
+			return nil
+		}
+
+		names := make([]string, 0, len(oids))
+		for _, oid := range oids {
+			if name, ok := extKeyUsages[oid.String()]; ok {
+				names = append(names, name)
+			} else {
+				names = append(names, oid.String())
+			}
+		}
+
+		return names
+	}
+
+	return nil
 }
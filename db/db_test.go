@@ -0,0 +1,61 @@
+package db
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewRecordExtKeyUsage(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		usages   []x509.ExtKeyUsage
+		unknown  []asn1.ObjectIdentifier
+		expected []string
+	}{
+		{"Named", []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, nil, []string{"serverAuth", "clientAuth"}},
+		{"Unnamed", []x509.ExtKeyUsage{x509.ExtKeyUsageIPSECUser, x509.ExtKeyUsageMicrosoftKernelCodeSigning}, nil, []string{"1.3.6.1.5.5.7.3.7", "1.3.6.1.4.1.311.61.1.1"}},
+		{"Unknown", []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, []asn1.ObjectIdentifier{{1, 2, 3, 4}}, []string{"codeSigning", "1.2.3.4"}},
+		{"None", nil, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			template := &x509.Certificate{
+				SerialNumber:       big.NewInt(1),
+				Subject:            pkix.Name{CommonName: "Test"},
+				NotBefore:          time.Now(),
+				NotAfter:           time.Now().Add(time.Hour),
+				ExtKeyUsage:        test.usages,
+				UnknownExtKeyUsage: test.unknown,
+			}
+
+			der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			record := NewRecord(cert, &Issuance{})
+			if !reflect.DeepEqual(record.ExtKeyUsage, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, record.ExtKeyUsage)
+			}
+		})
+	}
+}
@@ -1,31 +1,39 @@
 package db
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"crypto/sha256"
 	"crypto/x509"
-	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
-// JSONRecord is an individual certificate that has been signed by the
-// Certificate Authority.
-type JSONRecord struct {
-	Serial      string    `json:"serial"`
-	Fingerprint string    `json:"fingerprint"`
-	CommonName  string    `json:"commonName"`
-	NotBefore   time.Time `json:"notBefore"`
-	NotAfter    time.Time `json:"notAfter"`
-	DNSNames    []string  `json:"dnsNames,omitempty"`
-}
+// maxJSONRecordSize is the longest line that will be read from a JSON
+// database.
+const maxJSONRecordSize = 1 << 20
 
 // JSON is a DB implementation backed by an append-only newline delimited JSON
-// file.
+// file. Records are never modified in place, instead an updated copy of a
+// record is appended and takes precedence over any earlier copies.
+//
+// Each entry in the file carries the hash of the entry before it, chaining
+// them together so that edits, removals and reordering can be detected, and
+// the chain is periodically signed by the Certificate Authority with a
+// checkpoint.
+//
+// Access to the file is serialised between processes with an advisory lock on
+// a .lock file alongside it, so it may be shared by multiple invocations of
+// yubca.
 type JSON struct {
 	path  string
 	write sync.Mutex
@@ -35,34 +43,461 @@ func NewJSON(path string) (*JSON, error) {
 	return &JSON{path: path}, nil
 }
 
-func (j *JSON) AppendCertificate(ctx context.Context, cert *x509.Certificate) error {
-	j.write.Lock()
-	defer j.write.Unlock()
+func (j *JSON) Reserve(ctx context.Context, serial *big.Int, issuance *Issuance) error {
+	return j.withLock(true, func(file *os.File) error {
+		_, err := j.certificate(serial)
+		if err == nil {
+			return ErrExists
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		return j.appendEntry(file, &jsonEntry{Record: NewReservation(serial, issuance)})
+	})
+}
+
+func (j *JSON) AppendCertificate(ctx context.Context, cert *x509.Certificate, issuance *Issuance) error {
+	return j.withLock(true, func(file *os.File) error {
+		existing, err := j.certificate(cert.SerialNumber)
+		if err == nil {
+			err = existing.commit()
+			if err != nil {
+				return err
+			}
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		return j.appendEntry(file, &jsonEntry{Record: NewRecord(cert, issuance)})
+	})
+}
+
+func (j *JSON) Abandon(ctx context.Context, serial *big.Int) error {
+	return j.withLock(true, func(file *os.File) error {
+		record, err := j.certificate(serial)
+		if err != nil {
+			return err
+		} else if record.Reservation == nil {
+			return fmt.Errorf("certificate %s is not pending", record.Serial)
+		}
+
+		record.Reservation.Abandoned = true
+
+		return j.appendEntry(file, &jsonEntry{Record: record})
+	})
+}
+
+func (j *JSON) Certificate(ctx context.Context, serial *big.Int) (record *Record, err error) {
+	err = j.withLock(false, func(file *os.File) error {
+		record, err = j.certificate(serial)
+		return err
+	})
+
+	return
+}
+
+func (j *JSON) RevokeCertificate(ctx context.Context, serial *big.Int, revocation *Revocation) error {
+	return j.withLock(true, func(file *os.File) error {
+		record, err := j.certificate(serial)
+		if errors.Is(err, ErrNotFound) {
+			record = &Record{Version: RecordVersion, Serial: SerialString(serial)}
+		} else if err != nil {
+			return err
+		}
+
+		record.Revocation = revocation
+
+		return j.appendEntry(file, &jsonEntry{Record: record})
+	})
+}
+
+func (j *JSON) Certificates(ctx context.Context) (records []*Record, err error) {
+	err = j.withLock(false, func(file *os.File) error {
+		records, err = j.records()
+		return err
+	})
+
+	return
+}
+
+func (j *JSON) Search(ctx context.Context, query *Query) ([]*Record, error) {
+	records, err := j.Certificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var matches []*Record
+	for _, record := range records {
+		if query.Match(record, now) {
+			matches = append(matches, record)
+		}
+	}
+
+	return matches, nil
+}
+
+// Increment stores the counter called name in a file alongside the database,
+// suffixed with .counter. and its name.
+func (j *JSON) Increment(ctx context.Context, name string) (n *big.Int, err error) {
+	path := j.counterPath(name)
+
+	err = j.withLock(true, func(file *os.File) error {
+		n = new(big.Int)
+
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		} else if err == nil {
+			if _, ok := n.SetString(strings.TrimSpace(string(data)), 10); !ok {
+				return fmt.Errorf("%s: invalid counter value", path)
+			}
+		}
+
+		n.Add(n, big.NewInt(1))
+
+		return writeFileSync(path, []byte(n.String()+"\n"))
+	})
+
+	return
+}
+
+// Counters reads every counter file alongside the database.
+func (j *JSON) Counters(ctx context.Context) (map[string]*big.Int, error) {
+	entries, err := os.ReadDir(filepath.Dir(j.path))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(j.counterPath(""))
+
+	counters := make(map[string]*big.Int)
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || name == "" || strings.Contains(name, ".") || entry.IsDir() {
+			continue
+		}
 
-	record := &JSONRecord{
-		Serial:      hex.EncodeToString(cert.SerialNumber.Bytes()),
-		Fingerprint: sha256fingerprint(cert),
-		CommonName:  cert.Subject.CommonName,
-		NotBefore:   cert.NotBefore,
-		NotAfter:    cert.NotAfter,
-		DNSNames:    cert.DNSNames,
+		path := j.counterPath(name)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		n, ok := new(big.Int).SetString(strings.TrimSpace(string(data)), 10)
+		if !ok {
+			return nil, fmt.Errorf("%s: invalid counter value", path)
+		}
+
+		counters[name] = n
 	}
 
-	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	return counters, nil
+}
+
+func (j *JSON) Close() error {
+	return nil
+}
+
+func (j *JSON) counterPath(name string) string {
+	return j.path + ".counter." + name
+}
+
+// withLock calls fn while holding an advisory lock on the database, exclusive
+// for writing or shared for reading. file is opened for reading and writing
+// when exclusive, or nil when shared and the database does not yet exist.
+//
+// The lock is held on a separate lock file alongside the database, as locks
+// on Windows are mandatory and would otherwise prevent the database being
+// read through any other handle.
+func (j *JSON) withLock(exclusive bool, fn func(file *os.File) error) error {
+	var (
+		file *os.File
+		err  error
+	)
+
+	if exclusive {
+		j.write.Lock()
+		defer j.write.Unlock()
+
+		file, err = os.OpenFile(j.path, os.O_CREATE|os.O_RDWR, 0o644)
+	} else {
+		file, err = os.Open(j.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return fn(nil)
+		}
+	}
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	err = json.NewEncoder(file).Encode(record)
+	lock, err := os.OpenFile(j.path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
 	if err != nil {
-		return fmt.Errorf("could not marshal record: %w", err)
+		return fmt.Errorf("could not open lock file: %w", err)
 	}
+	defer lock.Close()
 
-	return nil
+	err = lockFile(lock, exclusive)
+	if err != nil {
+		return fmt.Errorf("could not lock %s: %w", j.path, err)
+	}
+	defer unlockFile(lock)
+
+	return fn(file)
+}
+
+// appendEntry chains entry to the last entry in the database and appends it
+// to file, which must be locked exclusively.
+func (j *JSON) appendEntry(file *os.File, entry *jsonEntry) error {
+	prev, err := repairTail(file)
+	if err != nil {
+		return fmt.Errorf("could not read last entry: %w", err)
+	}
+
+	return writeEntry(file, prev, entry)
+}
+
+// writeEntry appends entry to file following the entry with the hash prev,
+// and waits for it to be written to disk.
+func writeEntry(file *os.File, prev string, entry *jsonEntry) error {
+	entry.Prev = prev
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("could not marshal entry: %w", err)
+	}
+
+	_, err = file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+func (j *JSON) certificate(serial *big.Int) (*Record, error) {
+	records, err := j.records()
+	if err != nil {
+		return nil, err
+	}
+
+	str := SerialString(serial)
+
+	for _, record := range records {
+		if record.Serial == str {
+			return record, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// records reads every record from the database, in the order they were first
+// appended, with later copies of a record replacing earlier ones.
+func (j *JSON) records() ([]*Record, error) {
+	var (
+		records []*Record
+		index   = make(map[string]int)
+	)
+
+	err := j.entries(func(line int, raw []byte, entry *jsonEntry) error {
+		if entry.Record == nil {
+			return nil
+		}
+
+		record := entry.Record
+		record.upgrade()
+
+		if i, ok := index[record.Serial]; ok {
+			records[i] = record
+		} else {
+			index[record.Serial] = len(records)
+			records = append(records, record)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// entries calls fn with every entry in the database in the order they were
+// appended, along with their line number and raw encoding. A partially
+// written entry at the end of the database, left by an interrupted append, is
+// ignored and will be removed by the next append.
+func (j *JSON) entries(fn func(line int, raw []byte, entry *jsonEntry) error) error {
+	file, err := os.Open(j.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+
+	for line := 1; ; line++ {
+		raw, err := readLine(r)
+		if errors.Is(err, io.EOF) {
+			if len(raw) == 0 || !json.Valid(raw) {
+				return nil
+			}
+		} else if err != nil {
+			return fmt.Errorf("%s:%d: %w", j.path, line, err)
+		}
+
+		entry := new(jsonEntry)
+
+		err = json.Unmarshal(raw, entry)
+		if err != nil {
+			return fmt.Errorf("%s:%d: could not unmarshal entry: %w", j.path, line, err)
+		}
+
+		err = fn(line, raw, entry)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads the next line from r without its trailing newline, returning
+// io.EOF with any remaining bytes if there is no trailing newline.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+
+		if len(line) > maxJSONRecordSize {
+			return nil, fmt.Errorf("entry exceeds maximum size")
+		}
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		} else if err != nil {
+			return line, err
+		}
+
+		return line[:len(line)-1], nil
+	}
 }
 
-func sha256fingerprint(cert *x509.Certificate) string {
-	sum := sha256.Sum256(cert.Raw)
-	return "SHA256:" + base64.StdEncoding.EncodeToString(sum[:])
+// repairTail completes or removes a partially written entry at the end of
+// file, left by an interrupted append, and returns the hash of the last entry
+// in the database, or an empty string if the database is empty. file must be
+// locked exclusively.
+func repairTail(file *os.File) (string, error) {
+	line, start, complete, err := lastLine(file)
+	if err != nil {
+		return "", err
+	}
+
+	if !complete && len(line) > 0 {
+		if json.Valid(line) {
+			// only the newline was lost.
+			_, err = file.WriteAt([]byte("\n"), start+int64(len(line)))
+		} else {
+			err = file.Truncate(start)
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not repair partial entry: %w", err)
+		}
+
+		err = file.Sync()
+		if err != nil {
+			return "", err
+		}
+
+		line, _, _, err = lastLine(file)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(line) == 0 {
+		return "", nil
+	}
+
+	return entryHash(line), nil
+}
+
+// lastLine returns the last line of file without its trailing newline, the
+// offset it starts at, and whether it ended with a newline.
+func lastLine(file *os.File) (line []byte, start int64, complete bool, err error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	// read backwards from the end of the file until the start of the last
+	// line is found, ignoring its trailing newline.
+	var (
+		end = info.Size()
+		buf []byte
+	)
+	for end > 0 {
+		size := int64(4096)
+		if size > end {
+			size = end
+		}
+
+		chunk := make([]byte, size)
+
+		_, err = file.ReadAt(chunk, end-size)
+		if err != nil {
+			return nil, 0, false, err
+		}
+
+		buf = append(chunk, buf...)
+		end -= size
+
+		trimmed := bytes.TrimSuffix(buf, []byte("\n"))
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], end + int64(i) + 1, len(trimmed) < len(buf), nil
+		}
+
+		if len(buf) > maxJSONRecordSize {
+			return nil, 0, false, fmt.Errorf("last entry exceeds maximum size")
+		}
+	}
+
+	trimmed := bytes.TrimSuffix(buf, []byte("\n"))
+
+	return trimmed, 0, len(trimmed) < len(buf), nil
+}
+
+// writeFileSync replaces the file at path with data by way of a temporary
+// file, waiting for it to be written to disk.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
 }
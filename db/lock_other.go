@@ -0,0 +1,17 @@
+//go:build !unix && !windows
+
+package db
+
+import (
+	"os"
+)
+
+// lockFile is a no-op on platforms without advisory file locks, where only
+// the in-process lock of the database applies.
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,429 @@
+package db
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the tables of a SQLite database. Each record is stored
+// in full as JSON, with the columns it is searched by duplicated alongside it
+// and indexed.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS certificates (
+	id          INTEGER PRIMARY KEY,
+	serial      TEXT NOT NULL UNIQUE,
+	fingerprint TEXT NOT NULL,
+	common_name TEXT NOT NULL,
+	not_after   INTEGER,
+	revoked_at  INTEGER,
+	record      TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS certificates_fingerprint ON certificates (fingerprint);
+CREATE INDEX IF NOT EXISTS certificates_not_after ON certificates (not_after);
+
+CREATE TABLE IF NOT EXISTS sans (
+	serial TEXT NOT NULL REFERENCES certificates (serial),
+	type   TEXT NOT NULL,
+	value  TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sans_serial ON sans (serial);
+CREATE INDEX IF NOT EXISTS sans_value ON sans (type, value);
+
+CREATE TABLE IF NOT EXISTS counters (
+	name  TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// SQLite is a DB implementation backed by an embedded SQLite database.
+type SQLite struct {
+	db *sql.DB
+}
+
+func NewSQLite(path string) (*SQLite, error) {
+	// transactions begin immediately so that a write lock is taken before
+	// records are read, rather than failing to upgrade a read lock when a
+	// concurrent writer got there first.
+	dsn := url.URL{
+		Scheme:   "file",
+		Path:     path,
+		OmitHost: true,
+		RawQuery: url.Values{
+			"_pragma": {"busy_timeout(10000)", "journal_mode(WAL)", "foreign_keys(1)"},
+			"_txlock": {"immediate"},
+		}.Encode(),
+	}
+
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create schema: %w", err)
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+func (s *SQLite) Reserve(ctx context.Context, serial *big.Int, issuance *Issuance) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		_, err := getRecord(ctx, tx, SerialString(serial))
+		if err == nil {
+			return ErrExists
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		return putRecord(ctx, tx, NewReservation(serial, issuance), false)
+	})
+}
+
+func (s *SQLite) AppendCertificate(ctx context.Context, cert *x509.Certificate, issuance *Issuance) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		existing, err := getRecord(ctx, tx, SerialString(cert.SerialNumber))
+		if err == nil {
+			err = existing.commit()
+			if err != nil {
+				return err
+			}
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		return putRecord(ctx, tx, NewRecord(cert, issuance), true)
+	})
+}
+
+func (s *SQLite) Abandon(ctx context.Context, serial *big.Int) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		record, err := getRecord(ctx, tx, SerialString(serial))
+		if err != nil {
+			return err
+		} else if record.Reservation == nil {
+			return fmt.Errorf("certificate %s is not pending", record.Serial)
+		}
+
+		record.Reservation.Abandoned = true
+
+		return putRecord(ctx, tx, record, true)
+	})
+}
+
+func (s *SQLite) Certificate(ctx context.Context, serial *big.Int) (*Record, error) {
+	return getRecord(ctx, s.db, SerialString(serial))
+}
+
+func (s *SQLite) RevokeCertificate(ctx context.Context, serial *big.Int, revocation *Revocation) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		record, err := getRecord(ctx, tx, SerialString(serial))
+		if errors.Is(err, ErrNotFound) {
+			record = &Record{Version: RecordVersion, Serial: SerialString(serial)}
+		} else if err != nil {
+			return err
+		}
+
+		record.Revocation = revocation
+
+		return putRecord(ctx, tx, record, true)
+	})
+}
+
+func (s *SQLite) Certificates(ctx context.Context) ([]*Record, error) {
+	return s.Search(ctx, &Query{})
+}
+
+// Search narrows the records using the indexed columns of the database, the
+// query is then matched against each record to apply it exactly.
+func (s *SQLite) Search(ctx context.Context, query *Query) ([]*Record, error) {
+	var (
+		where []string
+		args  []any
+	)
+
+	if query.Serial != "" {
+		where = append(where, `serial LIKE ? ESCAPE '\'`)
+		args = append(args, likeEscape(normalizeSerialPrefix(query.Serial))+"%")
+	}
+
+	// LIKE only folds the case of ASCII, so other text is left to Match.
+	if query.CommonName != "" && isASCII(query.CommonName) {
+		where = append(where, `common_name LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscape(query.CommonName)+"%")
+	}
+
+	if query.DNSName != "" && isASCII(query.DNSName) {
+		where = append(where, `serial IN (SELECT serial FROM sans WHERE type = 'dns' AND value LIKE ? ESCAPE '\')`)
+		args = append(args, "%"+likeEscape(query.DNSName)+"%")
+	}
+
+	if query.Text != "" && isASCII(query.Text) {
+		where = append(where, `(serial LIKE ? ESCAPE '\' OR common_name LIKE ? ESCAPE '\' OR serial IN (SELECT serial FROM sans WHERE type = 'dns' AND value LIKE ? ESCAPE '\'))`)
+		args = append(args, likeEscape(normalizeSerialPrefix(query.Text))+"%", "%"+likeEscape(query.Text)+"%", "%"+likeEscape(query.Text)+"%")
+	}
+
+	if !query.ExpiresAfter.IsZero() {
+		where = append(where, "not_after >= ?")
+		args = append(args, query.ExpiresAfter.Unix())
+	}
+
+	if !query.ExpiresBefore.IsZero() {
+		where = append(where, "not_after <= ?")
+		args = append(args, query.ExpiresBefore.Unix())
+	}
+
+	switch query.Status {
+	case StatusRevoked:
+		where = append(where, "revoked_at IS NOT NULL")
+
+	case StatusValid, StatusExpired:
+		where = append(where, "revoked_at IS NULL")
+	}
+
+	stmt := "SELECT record FROM certificates"
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY id"
+
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+
+	var records []*Record
+	for rows.Next() {
+		var data []byte
+
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := unmarshalRecord(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if query.Match(record, now) {
+			records = append(records, record)
+		}
+	}
+
+	return records, rows.Err()
+}
+
+func (s *SQLite) Increment(ctx context.Context, name string) (*big.Int, error) {
+	n := new(big.Int)
+
+	err := s.tx(ctx, func(tx *sql.Tx) error {
+		var value string
+
+		err := tx.QueryRowContext(ctx, "SELECT value FROM counters WHERE name = ?", name).Scan(&value)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		} else if err == nil {
+			if _, ok := n.SetString(value, 10); !ok {
+				return fmt.Errorf("counter %q: invalid value", name)
+			}
+		}
+
+		n.Add(n, big.NewInt(1))
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO counters (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = excluded.value", name, n.String())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func (s *SQLite) Counters(ctx context.Context) (map[string]*big.Int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name, value FROM counters")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counters := make(map[string]*big.Int)
+	for rows.Next() {
+		var name, value string
+
+		err = rows.Scan(&name, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		n, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, fmt.Errorf("counter %q: invalid value", name)
+		}
+
+		counters[name] = n
+	}
+
+	return counters, rows.Err()
+}
+
+// Import adds records and counters migrated from another database, which
+// must not already exist in this database.
+func (s *SQLite) Import(ctx context.Context, records []*Record, counters map[string]*big.Int) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		for _, record := range records {
+			err := putRecord(ctx, tx, record, false)
+			if err != nil {
+				return fmt.Errorf("%s: %w", record.Serial, err)
+			}
+		}
+
+		for name, n := range counters {
+			_, err := tx.ExecContext(ctx, "INSERT INTO counters (name, value) VALUES (?, ?)", name, n.String())
+			if err != nil {
+				return fmt.Errorf("counter %q: %w", name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLite) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func getRecord(ctx context.Context, q queryer, serial string) (*Record, error) {
+	var data []byte
+
+	err := q.QueryRowContext(ctx, "SELECT record FROM certificates WHERE serial = ?", serial).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return unmarshalRecord(data)
+}
+
+// putRecord inserts record into the database, or if replace is set, replaces
+// any existing record with the same serial.
+func putRecord(ctx context.Context, tx *sql.Tx, record *Record, replace bool) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("could not marshal record: %w", err)
+	}
+
+	var revokedAt *int64
+	if record.Revocation != nil {
+		t := record.Revocation.Time.Unix()
+		revokedAt = &t
+	}
+
+	stmt := "INSERT INTO certificates (serial, fingerprint, common_name, not_after, revoked_at, record) VALUES (?, ?, ?, ?, ?, ?)"
+	if replace {
+		stmt += " ON CONFLICT (serial) DO UPDATE SET fingerprint = excluded.fingerprint, common_name = excluded.common_name, not_after = excluded.not_after, revoked_at = excluded.revoked_at, record = excluded.record"
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM sans WHERE serial = ?", record.Serial)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, stmt, record.Serial, record.Fingerprint, record.CommonName, nullUnix(record.NotAfter), revokedAt, data)
+	if err != nil {
+		return err
+	}
+
+	sans := map[string][]string{
+		"dns":   record.DNSNames,
+		"ip":    record.IPAddresses,
+		"email": record.EmailAddresses,
+		"uri":   record.URIs,
+	}
+
+	for typ, values := range sans {
+		for _, value := range values {
+			_, err = tx.ExecContext(ctx, "INSERT INTO sans (serial, type, value) VALUES (?, ?, ?)", record.Serial, typ, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func unmarshalRecord(data []byte) (*Record, error) {
+	record := new(Record)
+
+	err := json.Unmarshal(data, record)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal record: %w", err)
+	}
+
+	record.upgrade()
+
+	return record, nil
+}
+
+func nullUnix(t time.Time) *int64 {
+	if t.IsZero() {
+		return nil
+	}
+
+	n := t.Unix()
+	return &n
+}
+
+// likeEscape escapes the wildcards of a LIKE pattern in s.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
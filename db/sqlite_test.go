@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateJSONToSQLite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src, err := NewJSON(filepath.Join(dir, "issued.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// writing to the database leaves its lock file and a checkpoint alongside
+	// the counters, which must not be mistaken for them.
+	for i := int64(1); i <= 3; i++ {
+		err = src.Reserve(ctx, big.NewInt(i), &Issuance{Profile: "server"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, signer := testCA(t)
+
+	_, err = src.Checkpoint(ctx, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = src.Increment(ctx, "crlnumber")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := src.Certificates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counters, err := src.Counters(ctx)
+	if err != nil {
+		t.Fatalf("could not read counters: %s", err)
+	}
+
+	if len(counters) != 1 || counters["crlnumber"].Int64() != 2 {
+		t.Fatalf("expected crlnumber counter of 2, got %v", counters)
+	}
+
+	dest, err := NewSQLite(filepath.Join(dir, "issued.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dest.Close()
+
+	err = dest.Import(ctx, records, counters)
+	if err != nil {
+		t.Fatalf("could not import: %s", err)
+	}
+
+	migrated, err := dest.Certificates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(migrated) != len(records) {
+		t.Errorf("expected %d records, got %d", len(records), len(migrated))
+	}
+
+	n, err := dest.Increment(ctx, "crlnumber")
+	if err != nil {
+		t.Fatal(err)
+	} else if n.Int64() != 3 {
+		t.Errorf("expected crlnumber to continue from 3, got %s", n)
+	}
+}